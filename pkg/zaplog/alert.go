@@ -0,0 +1,486 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// AlertProvider webhook 的消息格式
+type AlertProvider int
+
+const (
+	AlertGeneric  AlertProvider = iota // POST Alert 的 JSON
+	AlertDingTalk                      // 钉钉自定义机器人, markdown 消息, Secret 为加签密钥
+	AlertFeishu                        // 飞书自定义机器人, text 消息, Secret 为签名校验密钥
+	AlertSlack                         // Slack incoming webhook
+)
+
+// Alert 一次告警, 同一个指纹在 Window 内只发送一次, 之后的条数合并到下一次.
+// 指纹为 logger, caller 和 NormalizeErrorMessage 归一化后的 message
+type Alert struct {
+	Fingerprint string                 `json:"fingerprint"`
+	Title       string                 `json:"title"`
+	Level       string                 `json:"level"`
+	Logger      string                 `json:"logger,omitempty"`
+	Caller      string                 `json:"caller,omitempty"`
+	Message     string                 `json:"message"`
+	Fields      map[string]interface{} `json:"fields,omitempty"` // 最近一条的字段
+	Count       int                    `json:"count"`            // 本次告警合并的条数
+	Window      time.Duration          `json:"window"`
+	First       time.Time              `json:"first"` // 合并的第一条时间
+	Last        time.Time              `json:"last"`
+}
+
+// DefaultAlertTemplate 钉钉/飞书/Slack 的消息正文, 可用 json 函数
+const DefaultAlertTemplate = `[{{.Level}}] {{.Title}}
+{{.Message}}
+{{if .Caller}}caller: {{.Caller}}
+{{end}}{{if .Logger}}logger: {{.Logger}}
+{{end}}{{if gt .Count 1}}count: {{.Count}} times in {{.Window}}
+{{end}}time: {{.Last.Format "2006-01-02 15:04:05"}}{{if .Fields}}
+fields: {{json .Fields}}{{end}}`
+
+// AlertConfig NewAlertCore 的配置
+type AlertConfig struct {
+	Provider AlertProvider
+	URL      string
+	Secret   string // 钉钉加签 / 飞书签名, 为空不签名
+	Title    string // 默认为主机名
+	// Template 正文模板, 数据为 Alert, 默认 DefaultAlertTemplate. AlertGeneric 设置了模板时请求体为模板输出
+	Template string
+	// AtMobiles 钉钉 @ 的手机号, AtAll @所有人
+	AtMobiles []string
+	AtAll     bool
+	// Window 同一指纹 (logger+caller+归一化的 message) 的聚合窗口, 窗口内第一条立即发送,
+	// 其余在窗口结束时合并为一条, 如 "count: 37 times in 5m0s". 默认 5m
+	Window time.Duration
+	// MaxPerWindow 每个 Window 内所有指纹合计最多发送的告警数, 超出的留到之后的窗口合并发送,
+	// 默认 20, 负数不限制
+	MaxPerWindow int
+	QueueSize    int           // 待处理日志和待发送告警的队列长度, 满了丢弃, 默认 1000
+	Workers      int           // 并发发送的请求数, 一个慢请求不影响其他告警, 默认 4
+	Timeout      time.Duration // 单次请求超时, 默认 5s
+	Client       *http.Client
+	// ErrorOutput 发送失败和丢弃的日志, 默认 stderr
+	ErrorOutput zapcore.WriteSyncer
+}
+
+// NewAlertCore 等级满足 enab 的日志发送到 webhook, 如 NewAlertCore(cfg, zapcore.ErrorLevel).
+// 发送在后台 goroutine, 写日志不会等待 webhook. 返回的 core 实现 io.Closer,
+// Close 发送窗口内还未发送的合并告警
+func NewAlertCore(cfg AlertConfig, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("log: alert webhook url is empty")
+	}
+	if cfg.Provider < AlertGeneric || cfg.Provider > AlertSlack {
+		return nil, fmt.Errorf("log: unsupported alert provider %d", cfg.Provider)
+	}
+	if cfg.Title == "" {
+		cfg.Title, _ = os.Hostname()
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 5 * time.Minute
+	}
+	if cfg.MaxPerWindow == 0 {
+		cfg.MaxPerWindow = 20
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if cfg.ErrorOutput == nil {
+		cfg.ErrorOutput = zapcore.Lock(os.Stderr)
+	}
+	text := cfg.Template
+	if text == "" {
+		text = DefaultAlertTemplate
+	}
+	tmpl, err := template.New("alert").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := stdjson.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("log: alert template: %w", err)
+	}
+
+	n := &alertNotifier{
+		cfg:    &cfg,
+		tmpl:   tmpl,
+		ch:     make(chan *Alert, cfg.QueueSize),
+		sendCh: make(chan *Alert, cfg.QueueSize),
+		groups: make(map[string]*alertGroup),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	n.workers.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go n.sendLoop()
+	}
+	go n.loop()
+	return &alertCore{LevelEnabler: enab, notifier: n}, nil
+}
+
+type alertCore struct {
+	zapcore.LevelEnabler
+	fields   []zapcore.Field
+	notifier *alertNotifier
+}
+
+func (c *alertCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+	return &clone
+}
+
+func (c *alertCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write 只放入队列, 队列满时丢弃并计数
+func (c *alertCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, group := range [][]zapcore.Field{c.fields, fields} {
+		for i := range group {
+			group[i].AddTo(enc)
+		}
+	}
+	a := &Alert{
+		Title:   c.notifier.cfg.Title,
+		Level:   ent.Level.CapitalString(),
+		Logger:  ent.LoggerName,
+		Message: ent.Message,
+		Count:   1,
+		Window:  c.notifier.cfg.Window,
+		First:   ent.Time,
+		Last:    ent.Time,
+	}
+	if ent.Caller.Defined {
+		a.Caller = ent.Caller.TrimmedPath()
+	}
+	if len(enc.Fields) > 0 {
+		a.Fields = enc.Fields
+	}
+	a.Fingerprint = alertFingerprint(a)
+	c.notifier.enqueue(a)
+	return nil
+}
+
+func (c *alertCore) Sync() error {
+	return nil
+}
+
+func (c *alertCore) Close() error {
+	return c.notifier.close()
+}
+
+// alertFingerprint caller 带行号, message 中的数字和 ID 归一化后同一行只有一个指纹
+func alertFingerprint(a *Alert) string {
+	return a.Logger + "|" + a.Caller + "|" + NormalizeErrorMessage(a.Message)
+}
+
+type alertGroup struct {
+	sentAt  time.Time // 上一次发送的时间, 窗口起点, 超出 MaxPerWindow 没有发送时为零值
+	pending *Alert    // 窗口内还没发送的合并告警
+}
+
+type alertNotifier struct {
+	cfg    *AlertConfig
+	tmpl   *template.Template
+	ch     chan *Alert
+	sendCh chan *Alert // loop 交给 sendLoop 发送
+
+	groups      map[string]*alertGroup // 以下只在 loop 中访问
+	windowStart time.Time
+	windowSent  int
+	dropped     uint64
+	workers     sync.WaitGroup
+
+	closeMu   sync.RWMutex
+	closed    bool
+	done      chan struct{}
+	exited    chan struct{}
+	closeOnce sync.Once
+}
+
+func (n *alertNotifier) enqueue(a *Alert) {
+	n.closeMu.RLock()
+	defer n.closeMu.RUnlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.ch <- a:
+	default:
+		atomic.AddUint64(&n.dropped, 1)
+	}
+}
+
+func (n *alertNotifier) loop() {
+	defer close(n.exited)
+	tick := n.cfg.Window / 10
+	if tick > time.Second {
+		tick = time.Second
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case a := <-n.ch:
+			n.receive(a, time.Now())
+		case now := <-ticker.C:
+			n.expire(now, false)
+		case <-n.done:
+			for {
+				select {
+				case a := <-n.ch:
+					n.receive(a, time.Now())
+				default:
+					n.expire(time.Now(), true)
+					return
+				}
+			}
+		}
+	}
+}
+
+// allow 本窗口是否还能发送, MaxPerWindow 对所有指纹合计
+func (n *alertNotifier) allow(now time.Time) bool {
+	if now.Sub(n.windowStart) >= n.cfg.Window {
+		n.windowStart, n.windowSent = now, 0
+	}
+	if n.cfg.MaxPerWindow > 0 && n.windowSent >= n.cfg.MaxPerWindow {
+		return false
+	}
+	n.windowSent++
+	return true
+}
+
+func (n *alertNotifier) receive(a *Alert, now time.Time) {
+	g, ok := n.groups[a.Fingerprint]
+	if !ok || now.Sub(g.sentAt) >= n.cfg.Window && g.pending == nil {
+		if !n.allow(now) {
+			// 超出总数的等之后的窗口合并发送
+			n.groups[a.Fingerprint] = &alertGroup{pending: a}
+			return
+		}
+		n.groups[a.Fingerprint] = &alertGroup{sentAt: now}
+		n.send(a)
+		return
+	}
+	if g.pending == nil {
+		g.pending = a
+		return
+	}
+	a.First = g.pending.First
+	a.Count = g.pending.Count + 1
+	g.pending = a
+}
+
+// expire 发送窗口已结束的合并告警, 清理空闲的指纹. all 为 true 时全部发送,
+// 超出 MaxPerWindow 的只报告条数
+func (n *alertNotifier) expire(now time.Time, all bool) {
+	limited := 0
+	keys := make([]string, 0, len(n.groups))
+	for k := range n.groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		g := n.groups[k]
+		if !all && now.Sub(g.sentAt) < n.cfg.Window {
+			continue
+		}
+		if g.pending == nil {
+			delete(n.groups, k)
+			continue
+		}
+		if !n.allow(now) {
+			if all {
+				limited += g.pending.Count
+			}
+			continue
+		}
+		n.send(g.pending)
+		g.pending, g.sentAt = nil, now
+	}
+	if limited > 0 {
+		n.errorf("alert send limit reached, %d entries not sent", limited)
+	}
+	if dropped := atomic.SwapUint64(&n.dropped, 0); dropped > 0 {
+		n.errorf("alert queue is full, %d entries dropped", dropped)
+	}
+}
+
+func (n *alertNotifier) close() error {
+	n.closeOnce.Do(func() {
+		n.closeMu.Lock()
+		n.closed = true
+		n.closeMu.Unlock()
+		close(n.done)
+		<-n.exited
+		close(n.sendCh)
+	})
+	<-n.exited
+	n.workers.Wait()
+	return nil
+}
+
+func (n *alertNotifier) errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf("%s log: "+format+"\n", append([]interface{}{time.Now().Format(time.RFC3339)}, args...)...)
+	_, _ = n.cfg.ErrorOutput.Write([]byte(msg))
+}
+
+// send 交给 sendLoop, 队列满时丢弃并计数
+func (n *alertNotifier) send(a *Alert) {
+	select {
+	case n.sendCh <- a:
+	default:
+		atomic.AddUint64(&n.dropped, 1)
+	}
+}
+
+func (n *alertNotifier) sendLoop() {
+	defer n.workers.Done()
+	for a := range n.sendCh {
+		if err := n.post(a); err != nil {
+			n.errorf("alert webhook: %v", err)
+		}
+	}
+}
+
+func (n *alertNotifier) post(a *Alert) error {
+	body, u, err := n.encode(a, time.Now())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := n.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(data))
+	}
+	// 钉钉和飞书出错时也返回 200, 错误码在 body 里
+	var ret struct {
+		ErrCode *int   `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+		Code    *int   `json:"code"`
+		Msg     string `json:"msg"`
+	}
+	if n.cfg.Provider == AlertDingTalk || n.cfg.Provider == AlertFeishu {
+		if stdjson.Unmarshal(data, &ret) == nil {
+			if ret.ErrCode != nil && *ret.ErrCode != 0 {
+				return fmt.Errorf("errcode %d: %s", *ret.ErrCode, ret.ErrMsg)
+			}
+			if ret.Code != nil && *ret.Code != 0 {
+				return fmt.Errorf("code %d: %s", *ret.Code, ret.Msg)
+			}
+		}
+	}
+	return nil
+}
+
+// encode 返回请求体和请求地址 (钉钉加签时带 timestamp 和 sign 参数)
+func (n *alertNotifier) encode(a *Alert, now time.Time) ([]byte, string, error) {
+	cfg := n.cfg
+	if cfg.Provider == AlertGeneric && cfg.Template == "" {
+		body, err := stdjson.Marshal(a)
+		return body, cfg.URL, err
+	}
+	var text strings.Builder
+	if err := n.tmpl.Execute(&text, a); err != nil {
+		return nil, "", fmt.Errorf("alert template: %w", err)
+	}
+
+	var msg interface{}
+	u := cfg.URL
+	switch cfg.Provider {
+	case AlertGeneric:
+		return []byte(text.String()), u, nil
+	case AlertDingTalk:
+		content := text.String()
+		for _, m := range cfg.AtMobiles {
+			content += " @" + m
+		}
+		msg = map[string]interface{}{
+			"msgtype": "markdown",
+			"markdown": map[string]string{
+				"title": fmt.Sprintf("[%s] %s", a.Level, a.Title),
+				// markdown 中单个换行不生效
+				"text": strings.ReplaceAll(content, "\n", "\n\n"),
+			},
+			"at": map[string]interface{}{"atMobiles": cfg.AtMobiles, "isAtAll": cfg.AtAll},
+		}
+		if cfg.Secret != "" {
+			ts := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+			mac := hmac.New(sha256.New, []byte(cfg.Secret))
+			mac.Write([]byte(ts + "\n" + cfg.Secret))
+			sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+			sep := "?"
+			if strings.Contains(u, "?") {
+				sep = "&"
+			}
+			u += sep + "timestamp=" + ts + "&sign=" + url.QueryEscape(sign)
+		}
+	case AlertFeishu:
+		m := map[string]interface{}{
+			"msg_type": "text",
+			"content":  map[string]string{"text": text.String()},
+		}
+		if cfg.Secret != "" {
+			// 飞书以 timestamp + "\n" + secret 为 key 对空串签名, 时间戳单位秒
+			ts := strconv.FormatInt(now.Unix(), 10)
+			mac := hmac.New(sha256.New, []byte(ts+"\n"+cfg.Secret))
+			m["timestamp"] = ts
+			m["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		}
+		msg = m
+	case AlertSlack:
+		msg = map[string]string{"text": text.String()}
+	}
+	body, err := stdjson.Marshal(msg)
+	return body, u, err
+}
@@ -0,0 +1,226 @@
+package log
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	stdjson "encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type alertServer struct {
+	mu     sync.Mutex
+	bodies []string
+	urls   []string
+	block  chan struct{} // 不为 nil 时请求阻塞到关闭
+}
+
+func (s *alertServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.block != nil {
+		<-s.block
+	}
+	data, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	s.bodies = append(s.bodies, string(data))
+	s.urls = append(s.urls, r.URL.String())
+	s.mu.Unlock()
+	w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+}
+
+func TestAlertDingTalkAggregate(t *testing.T) {
+	server := &alertServer{}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	core, err := NewAlertCore(AlertConfig{
+		Provider:  AlertDingTalk,
+		URL:       srv.URL + "/robot/send?access_token=x",
+		Secret:    "SEC123",
+		Title:     "order-api",
+		AtMobiles: []string{"13800000000"},
+		Window:    100 * time.Millisecond,
+	}, zapcore.ErrorLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewZapLoggerWithOptions(nil, WithExtraCores(core), WithFallbackWriters(&syncBuffer{}))
+	l.Warn("not an alert")
+	for i := 0; i < 5; i++ {
+		l.Error("db timeout", zap.Int("i", i))
+	}
+	l.Error("other")
+	time.Sleep(250 * time.Millisecond)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	// db timeout 和 other 各一条, 窗口结束后 db timeout 合并的 4 条
+	if len(server.bodies) != 3 {
+		t.Fatalf("bodies %q", server.bodies)
+	}
+	var msg struct {
+		Markdown struct{ Title, Text string }
+		At       struct{ AtMobiles []string }
+	}
+	if err := stdjson.Unmarshal([]byte(server.bodies[2]), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Markdown.Title != "[ERROR] order-api" || !strings.Contains(msg.Markdown.Text, "db timeout") ||
+		!strings.Contains(msg.Markdown.Text, "count: 4 times in 100ms") || !strings.Contains(msg.Markdown.Text, `"i":4`) ||
+		len(msg.At.AtMobiles) != 1 {
+		t.Fatalf("message %+v", msg)
+	}
+
+	u := server.urls[0]
+	q, _ := http.NewRequest("GET", u, nil)
+	ts, sign := q.URL.Query().Get("timestamp"), q.URL.Query().Get("sign")
+	mac := hmac.New(sha256.New, []byte("SEC123"))
+	mac.Write([]byte(ts + "\nSEC123"))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); sign != want || q.URL.Query().Get("access_token") != "x" {
+		t.Fatalf("url %s, want sign %s", u, want)
+	}
+}
+
+func TestAlertNonBlocking(t *testing.T) {
+	server := &alertServer{block: make(chan struct{})}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+	errOut := &syncBuffer{}
+
+	core, err := NewAlertCore(AlertConfig{URL: srv.URL, QueueSize: 1, ErrorOutput: errOut, Window: time.Hour}, zapcore.ErrorLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(core)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		logger.Error("boom", zap.Int("i", i))
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("logging blocked for %v", elapsed)
+	}
+	close(server.block)
+	core.(io.Closer).Close()
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	var first Alert
+	if err := stdjson.Unmarshal([]byte(server.bodies[0]), &first); err != nil || first.Message != "boom" || first.Level != "ERROR" {
+		t.Fatalf("generic body %q: %v", server.bodies[0], err)
+	}
+	if !strings.Contains(errOut.String(), "entries dropped") {
+		t.Fatalf("error output %q", errOut.String())
+	}
+}
+
+func TestAlertProviderBodies(t *testing.T) {
+	a := &Alert{Title: "t", Level: "ERROR", Message: "m", Count: 3, Window: time.Minute}
+	for _, tt := range []struct {
+		cfg  AlertConfig
+		want string
+	}{
+		{AlertConfig{Provider: AlertSlack}, `{"text":"[ERROR] t\nm\ncount: 3 times in 1m0s\ntime: 0001-01-01 00:00:00"}`},
+		{AlertConfig{Provider: AlertFeishu}, `{"content":{"text":"[ERROR] t\nm\ncount: 3 times in 1m0s\ntime: 0001-01-01 00:00:00"},"msg_type":"text"}`},
+		{AlertConfig{Template: `{"summary":"{{.Message}} x{{.Count}}"}`}, `{"summary":"m x3"}`},
+	} {
+		tt.cfg.URL = "http://localhost"
+		core, err := NewAlertCore(tt.cfg, zapcore.ErrorLevel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _, err := core.(*alertCore).notifier.encode(a, time.Unix(1700000000, 0))
+		core.(io.Closer).Close()
+		if err != nil || string(body) != tt.want {
+			t.Fatalf("provider %d body %s: %v", tt.cfg.Provider, body, err)
+		}
+	}
+}
+
+func TestAlertSendLimit(t *testing.T) {
+	server := &alertServer{}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+	errOut := &syncBuffer{}
+
+	core, err := NewAlertCore(AlertConfig{URL: srv.URL, MaxPerWindow: 2, Window: time.Hour, ErrorOutput: errOut}, zapcore.ErrorLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(core)
+	// 数字不同的 message 归一化后是同一个指纹, 只发送第一条
+	for i := 0; i < 5; i++ {
+		logger.Error("order " + strconv.Itoa(i) + " not found")
+	}
+	// 不同的指纹受窗口内总数限制
+	for _, msg := range []string{"disk full", "db down", "cache miss"} {
+		logger.Error(msg)
+	}
+	core.(io.Closer).Close()
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.bodies) != 2 {
+		t.Fatalf("bodies %q", server.bodies)
+	}
+	if !strings.Contains(errOut.String(), "alert send limit reached, 6 entries not sent") {
+		t.Fatalf("error output %q", errOut.String())
+	}
+}
+
+func TestAlertSlowWebhook(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		_ = stdjson.NewDecoder(r.Body).Decode(&a)
+		if a.Message == "slow" {
+			<-release
+		}
+		mu.Lock()
+		got = append(got, a.Message)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	core, err := NewAlertCore(AlertConfig{URL: srv.URL, Window: time.Hour}, zapcore.ErrorLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(core)
+	logger.Error("slow")
+	logger.Error("fast")
+	// 一个慢请求不影响其他告警发送
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("fast alert waited for the slow webhook")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(release)
+	core.(io.Closer).Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 || got[0] != "fast" {
+		t.Fatalf("sent %q", got)
+	}
+}
@@ -0,0 +1,263 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+// ErrSinkClosed 对已经 Close 的 sink 写入
+var ErrSinkClosed = errors.New("log: sink is closed")
+
+// DefaultSinkQueueSize 网络 sink 默认最多排队的满批次数
+const DefaultSinkQueueSize = 8
+
+// DefaultSinkFlushTimeout Sync 和 Close 最多等待多久, 超时后中断重试并丢弃剩余的批次
+const DefaultSinkFlushTimeout = 5 * time.Second
+
+// batcherConfig newBatcher 的配置, MaxItems 和 MaxBytes 任一达到即为满批
+type batcherConfig struct {
+	MaxItems     int
+	MaxBytes     int
+	QueueSize    int // 等待发送的满批次数
+	Interval     time.Duration
+	FlushTimeout time.Duration // 默认 DefaultSinkFlushTimeout
+}
+
+// batcher 网络 sink 共用的缓冲和发送: add 只追加到内存, 满批放进队列由后台 goroutine
+// 按顺序交给 send, 未满的批次每 Interval 发送一次. 队列满时丢弃新满的批次并计数,
+// 写日志的 goroutine 不会等待网络或重试. flush 超时后取消 send 的 ctx, 中断请求和退避,
+// 剩余的批次丢弃并计数
+type batcher struct {
+	cfg  batcherConfig
+	send func(ctx context.Context, items []interface{}) error // 只在后台 goroutine 中调用
+
+	mu      sync.Mutex
+	ctx     context.Context // 传给 send, flush 超时后取消并换一个新的
+	cancel  context.CancelFunc
+	items   []interface{}
+	size    int
+	pending [][]interface{} // 等待发送的满批
+	closed  bool
+	err     error  // 后台发送的第一个错误, 由下一次 flush 返回
+	lost    uint64 // 上次 flush 之后丢弃的条数
+
+	dropped   uint64 // 累计丢弃的条数
+	wake      chan struct{}
+	flushReq  chan chan struct{}
+	done      chan struct{}
+	exited    chan struct{}
+	closeOnce sync.Once
+}
+
+func newBatcher(cfg batcherConfig, send func(ctx context.Context, items []interface{}) error) *batcher {
+	if cfg.FlushTimeout <= 0 {
+		cfg.FlushTimeout = DefaultSinkFlushTimeout
+	}
+	b := &batcher{
+		cfg:      cfg,
+		send:     send,
+		wake:     make(chan struct{}, 1),
+		flushReq: make(chan chan struct{}),
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	go b.loop()
+	return b
+}
+
+// add 追加一条, size 用于 MaxBytes
+func (b *batcher) add(item interface{}, size int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrSinkClosed
+	}
+	b.items = append(b.items, item)
+	b.size += size
+	if b.cfg.MaxItems > 0 && len(b.items) >= b.cfg.MaxItems || b.cfg.MaxBytes > 0 && b.size >= b.cfg.MaxBytes {
+		if len(b.pending) >= b.cfg.QueueSize {
+			b.dropLocked(len(b.items))
+		} else {
+			b.pending = append(b.pending, b.items)
+			select {
+			case b.wake <- struct{}{}:
+			default:
+			}
+		}
+		b.items, b.size = nil, 0
+	}
+	return nil
+}
+
+func (b *batcher) dropLocked(n int) {
+	b.lost += uint64(n)
+	atomic.AddUint64(&b.dropped, uint64(n))
+}
+
+// flush 等待缓冲和队列中的批次发送完, 返回此前的发送错误和丢弃的条数.
+// 超过 FlushTimeout 时中断正在进行的发送, 没有发出的批次算作丢弃
+func (b *batcher) flush() error {
+	ch := make(chan struct{})
+	go func() {
+		select {
+		case b.flushReq <- ch:
+		case <-b.exited:
+			close(ch)
+		}
+	}()
+	timer := time.NewTimer(b.cfg.FlushTimeout)
+	select {
+	case <-ch:
+	case <-timer.C:
+		b.mu.Lock()
+		b.cancel()
+		b.ctx, b.cancel = context.WithCancel(context.Background())
+		b.mu.Unlock()
+		<-ch
+	}
+	timer.Stop()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.err
+	if b.lost > 0 {
+		err = multierr.Append(err, fmt.Errorf("log: sink queue is full, dropped %d entries", b.lost))
+	}
+	b.err, b.lost = nil, 0
+	return err
+}
+
+// close 发送剩余的批次并停止后台 goroutine, 之后的 add 返回 ErrSinkClosed
+func (b *batcher) close() error {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		b.closed = true
+		b.mu.Unlock()
+		close(b.done)
+	})
+	err := b.flush()
+	b.mu.Lock()
+	b.cancel()
+	b.mu.Unlock()
+	return err
+}
+
+func (b *batcher) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+func (b *batcher) loop() {
+	defer close(b.exited)
+	ticker := time.NewTicker(b.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.wake:
+			b.sendPending(false)
+		case <-ticker.C:
+			b.sendPending(true)
+		case ch := <-b.flushReq:
+			b.sendPending(true)
+			close(ch)
+		case <-b.done:
+			b.sendPending(true)
+			return
+		}
+	}
+}
+
+// sendPending 发送队列中的满批, all 为 true 时连同未满的批次
+func (b *batcher) sendPending(all bool) {
+	b.mu.Lock()
+	ctx := b.ctx
+	batches := b.pending
+	b.pending = nil
+	if all && len(b.items) > 0 {
+		batches = append(batches, b.items)
+		b.items, b.size = nil, 0
+	}
+	b.mu.Unlock()
+	for _, items := range batches {
+		err := ctx.Err()
+		if err == nil {
+			err = b.send(ctx, items)
+		}
+		if err == nil {
+			continue
+		}
+		b.mu.Lock()
+		if ctx.Err() != nil {
+			b.dropLocked(len(items))
+		} else if b.err == nil {
+			b.err = err
+		}
+		b.mu.Unlock()
+	}
+}
+
+// retryPolicy 网络 sink 共用的重试, 只在 batcher 的后台 goroutine 中使用
+type retryPolicy struct {
+	maxRetries int // 负数不重试, maxElapsed 大于 0 时不限次数
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	maxElapsed time.Duration // 大于 0 时为总的重试时间
+	jitter     bool          // 退避时间在 [backoff/2, backoff) 内随机
+}
+
+// do 调用 f 直到成功, 不能重试或 ctx 取消. f 返回的 wait 是服务端要求的等待 (Retry-After),
+// 不超过 maxBackoff, 避免一个错误的响应头让整个 sink 停下来
+func (p retryPolicy) do(ctx context.Context, f func() (retry bool, wait time.Duration, err error)) error {
+	deadline := time.Now().Add(p.maxElapsed)
+	backoff := p.minBackoff
+	for attempt := 0; ; attempt++ {
+		retry, wait, err := f()
+		if err == nil || !retry || p.maxElapsed <= 0 && attempt >= p.maxRetries {
+			return err
+		}
+		if wait > p.maxBackoff {
+			wait = p.maxBackoff
+		}
+		if wait <= 0 {
+			wait = backoff
+			if p.jitter {
+				wait = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+			}
+			if backoff *= 2; backoff > p.maxBackoff {
+				backoff = p.maxBackoff
+			}
+		}
+		if p.maxElapsed > 0 && time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// retryAfter 解析秒数或 HTTP 时间格式的 Retry-After
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(v); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
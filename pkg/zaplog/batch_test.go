@@ -0,0 +1,93 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatcherOrderAndOverflow(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var sent [][]interface{}
+	b := newBatcher(batcherConfig{MaxItems: 2, QueueSize: 1, Interval: time.Hour}, func(ctx context.Context, items []interface{}) error {
+		<-release
+		mu.Lock()
+		sent = append(sent, items)
+		mu.Unlock()
+		return nil
+	})
+
+	// 后台卡在第一批时 add 不等待, 超出队列的满批被丢弃
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := b.add(i, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("add blocked for %s", d)
+	}
+	if b.Dropped() < 6 {
+		t.Fatalf("dropped %d", b.Dropped())
+	}
+	close(release)
+	if err := b.close(); err == nil || !strings.Contains(err.Error(), "dropped") {
+		t.Fatalf("close: %v", err)
+	}
+	if err := b.add(0, 0); !errors.Is(err, ErrSinkClosed) {
+		t.Fatalf("add after close: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	last := -1
+	for _, items := range sent {
+		for _, item := range items {
+			if item.(int) <= last {
+				t.Fatalf("out of order: %v", sent)
+			}
+			last = item.(int)
+		}
+	}
+}
+
+func TestRetryPolicyCapsRetryAfter(t *testing.T) {
+	p := retryPolicy{maxRetries: 1, minBackoff: time.Millisecond, maxBackoff: 10 * time.Millisecond}
+	calls := 0
+	start := time.Now()
+	err := p.do(context.Background(), func() (bool, time.Duration, error) {
+		calls++
+		return true, time.Hour, errors.New("busy")
+	})
+	if err == nil || calls != 2 || time.Since(start) > time.Second {
+		t.Fatalf("err %v, calls %d, took %s", err, calls, time.Since(start))
+	}
+}
+
+func TestBatcherCloseInterruptsRetry(t *testing.T) {
+	// 下游一直要求重试时 close 在 FlushTimeout 后中断退避, 未发出的条数计入丢弃
+	policy := retryPolicy{maxRetries: 100, minBackoff: time.Hour, maxBackoff: time.Hour}
+	b := newBatcher(batcherConfig{MaxItems: 2, QueueSize: 4, Interval: time.Hour, FlushTimeout: 50 * time.Millisecond},
+		func(ctx context.Context, items []interface{}) error {
+			return policy.do(ctx, func() (bool, time.Duration, error) {
+				return true, 0, errors.New("busy")
+			})
+		})
+	for i := 0; i < 5; i++ {
+		if err := b.add(i, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	err := b.close()
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("close blocked for %s", d)
+	}
+	if err == nil || !strings.Contains(err.Error(), "dropped 5 entries") || b.Dropped() != 5 {
+		t.Fatalf("close: %v, dropped %d", err, b.Dropped())
+	}
+}
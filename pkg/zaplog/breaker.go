@@ -0,0 +1,319 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap/zapcore"
+)
+
+// BreakerState 熔断器状态
+type BreakerState int32
+
+const (
+	BreakerClosed   BreakerState = iota // 正常写入
+	BreakerOpen                         // 直接返回 ErrBreakerOpen, 不再访问下游
+	BreakerHalfOpen                     // 放一条试探写入, 成功则关闭, 失败重新打开
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int32(s))
+}
+
+var (
+	// ErrBreakerOpen 熔断打开或半开时已有试探写入在进行
+	ErrBreakerOpen = errors.New("log: circuit breaker is open")
+	// ErrWriteTimeout 写入在 BreakerConfig.Timeout 内没有返回
+	ErrWriteTimeout = errors.New("log: write timeout")
+)
+
+// BreakerConfig NewBreakerWriter 的配置
+type BreakerConfig struct {
+	Name string // 出现在状态变化日志里
+	// Timeout 单次 Write/Sync 的超时, 包括排队等前一次调用的时间. 调用串行执行,
+	// 超时的调用在后台继续, 它没返回前新的写入直接算超时. 默认 1s
+	Timeout          time.Duration
+	FailureThreshold int           // 连续失败多少次打开, 默认 5
+	OpenDuration     time.Duration // 打开多久后进入半开, 默认 30s
+	// OnStateChange 状态变化回调, 在写入的 goroutine 中同步调用
+	OnStateChange func(name string, from, to BreakerState)
+	// ErrorOutput 状态变化写一行日志, 默认 stderr
+	ErrorOutput zapcore.WriteSyncer
+}
+
+// BreakerStats 熔断器计数
+type BreakerStats struct {
+	State    BreakerState
+	Opens    uint64 // 打开次数
+	Rejected uint64 // 打开期间拒绝的写入
+	Timeouts uint64
+	Failures uint64 // 包含超时
+}
+
+// BreakerWriter 带超时和熔断的 WriteSyncer, 下游卡住时不拖慢同步写日志的调用方
+type BreakerWriter struct {
+	ws  zapcore.WriteSyncer
+	cfg *BreakerConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int // 连续失败次数
+	openedAt time.Time
+	trial    bool // 半开状态下已有试探写入
+
+	sem       chan struct{} // 下游调用串行执行
+	busySince int64         // 进行中的调用的开始时间, UnixNano, 0 为空闲
+
+	opens, rejected, timeouts, failed uint64
+}
+
+// NewBreakerWriter 包装 ws, 一般用于网络 sink, 再放进 NewFailoverWriter 的链上
+func NewBreakerWriter(ws zapcore.WriteSyncer, cfg BreakerConfig) *BreakerWriter {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = 30 * time.Second
+	}
+	if cfg.ErrorOutput == nil {
+		cfg.ErrorOutput = zapcore.Lock(os.Stderr)
+	}
+	return &BreakerWriter{ws: ws, cfg: &cfg, sem: make(chan struct{}, 1)}
+}
+
+func (b *BreakerWriter) Write(p []byte) (int, error) {
+	if err := b.allow(); err != nil {
+		return 0, err
+	}
+	// zap 会复用 p, 超时后后台的写入不能再引用它
+	data := append([]byte(nil), p...)
+	var n int
+	err := b.call(func() (err error) {
+		n, err = b.ws.Write(data)
+		return err
+	})
+	b.done(err)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Sync 打开时跳过, 避免退出时卡在已知不可用的下游
+func (b *BreakerWriter) Sync() error {
+	if b.State() == BreakerOpen {
+		return nil
+	}
+	return b.call(b.ws.Sync)
+}
+
+// Close 下游是本包创建的 writer 时关闭
+func (b *BreakerWriter) Close() error {
+	if cl, ok := ownedCloser(b.ws); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+// State 当前状态, 打开超过 OpenDuration 时报告为半开
+func (b *BreakerWriter) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cfg.OpenDuration {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+func (b *BreakerWriter) Stats() BreakerStats {
+	return BreakerStats{
+		State:    b.State(),
+		Opens:    atomic.LoadUint64(&b.opens),
+		Rejected: atomic.LoadUint64(&b.rejected),
+		Timeouts: atomic.LoadUint64(&b.timeouts),
+		Failures: atomic.LoadUint64(&b.failed),
+	}
+}
+
+func (b *BreakerWriter) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cfg.OpenDuration {
+		b.setState(BreakerHalfOpen, nil)
+	}
+	switch {
+	case b.state == BreakerOpen, b.state == BreakerHalfOpen && b.trial:
+		atomic.AddUint64(&b.rejected, 1)
+		return ErrBreakerOpen
+	case b.state == BreakerHalfOpen:
+		b.trial = true
+	}
+	return nil
+}
+
+func (b *BreakerWriter) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if err == nil {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.setState(BreakerClosed, nil)
+		}
+		return
+	}
+	atomic.AddUint64(&b.failed, 1)
+	b.failures++
+	if b.state == BreakerHalfOpen || b.state == BreakerClosed && b.failures >= b.cfg.FailureThreshold {
+		b.openedAt = time.Now()
+		atomic.AddUint64(&b.opens, 1)
+		b.setState(BreakerOpen, err)
+	}
+}
+
+// setState 调用时持有 mu
+func (b *BreakerWriter) setState(to BreakerState, cause error) {
+	from := b.state
+	b.state = to
+	msg := fmt.Sprintf("%s log: breaker %q %s -> %s", time.Now().Format(time.RFC3339), b.cfg.Name, from, to)
+	if cause != nil {
+		msg += fmt.Sprintf(" after %d failures: %v", b.failures, cause)
+	}
+	_, _ = b.cfg.ErrorOutput.Write([]byte(msg + "\n"))
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(b.cfg.Name, from, to)
+	}
+}
+
+// call 在超时内执行 f, 并发的调用排队, 排队时间也算在超时里.
+// 进行中的调用已经超时 (下游卡住) 时不排队, 直接失败
+func (b *BreakerWriter) call(f func() error) error {
+	timer := time.NewTimer(b.cfg.Timeout)
+	defer timer.Stop()
+	select {
+	case b.sem <- struct{}{}:
+	default:
+		if since := atomic.LoadInt64(&b.busySince); since != 0 && time.Since(time.Unix(0, since)) >= b.cfg.Timeout {
+			atomic.AddUint64(&b.timeouts, 1)
+			return ErrWriteTimeout
+		}
+		select {
+		case b.sem <- struct{}{}:
+		case <-timer.C:
+			atomic.AddUint64(&b.timeouts, 1)
+			return ErrWriteTimeout
+		}
+	}
+	atomic.StoreInt64(&b.busySince, time.Now().UnixNano())
+	ch := make(chan error, 1)
+	go func() {
+		err := f()
+		atomic.StoreInt64(&b.busySince, 0)
+		<-b.sem
+		ch <- err
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-timer.C:
+		atomic.AddUint64(&b.timeouts, 1)
+		return ErrWriteTimeout
+	}
+}
+
+// FailoverWriter 按顺序尝试 writer, 写入第一个成功的, 如 远端 -> 本地文件 -> stderr
+type FailoverWriter struct {
+	ws          []zapcore.WriteSyncer
+	errorOutput zapcore.WriteSyncer
+
+	active   int32 // 上一次写入成功的下标
+	switches uint64
+	served   []uint64
+}
+
+// NewFailoverWriter 远端 writer 一般先用 NewBreakerWriter 包装, 不可用时能立刻切到下一个,
+// errorOutput 记录切换, 为 nil 时写 stderr
+func NewFailoverWriter(errorOutput zapcore.WriteSyncer, ws ...zapcore.WriteSyncer) *FailoverWriter {
+	if errorOutput == nil {
+		errorOutput = zapcore.Lock(os.Stderr)
+	}
+	return &FailoverWriter{ws: ws, errorOutput: errorOutput, served: make([]uint64, len(ws))}
+}
+
+func (f *FailoverWriter) Write(p []byte) (int, error) {
+	var errs error
+	for i, w := range f.ws {
+		n, err := w.Write(p)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		atomic.AddUint64(&f.served[i], 1)
+		if prev := atomic.SwapInt32(&f.active, int32(i)); prev != int32(i) {
+			atomic.AddUint64(&f.switches, 1)
+			msg := fmt.Sprintf("%s log: failover writer %d -> %d", time.Now().Format(time.RFC3339), prev, i)
+			if errs != nil {
+				msg += fmt.Sprintf(": %v", errs)
+			}
+			_, _ = f.errorOutput.Write([]byte(msg + "\n"))
+		}
+		return n, nil
+	}
+	if errs == nil {
+		errs = errors.New("log: failover writer has no writers")
+	}
+	return 0, errs
+}
+
+func (f *FailoverWriter) Sync() error {
+	var err error
+	for _, w := range f.ws {
+		err = multierr.Append(err, w.Sync())
+	}
+	return err
+}
+
+func (f *FailoverWriter) Close() error {
+	var err error
+	for _, w := range f.ws {
+		if cl, ok := ownedCloser(w); ok {
+			err = multierr.Append(err, cl.Close())
+		}
+	}
+	return err
+}
+
+// Active 最近一次写入成功的 writer 下标
+func (f *FailoverWriter) Active() int {
+	return int(atomic.LoadInt32(&f.active))
+}
+
+// Switches 切换次数
+func (f *FailoverWriter) Switches() uint64 {
+	return atomic.LoadUint64(&f.switches)
+}
+
+// Served 每个 writer 成功写入的条数
+func (f *FailoverWriter) Served() []uint64 {
+	ret := make([]uint64, len(f.served))
+	for i := range f.served {
+		ret[i] = atomic.LoadUint64(&f.served[i])
+	}
+	return ret
+}
@@ -0,0 +1,132 @@
+package log
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowWriter 每次 Write 耗时 delay, 同时只能有一个调用
+type slowWriter struct {
+	syncBuffer
+	delay    time.Duration
+	inflight int32
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	if !atomic.CompareAndSwapInt32(&w.inflight, 0, 1) {
+		return 0, errors.New("concurrent write")
+	}
+	defer atomic.StoreInt32(&w.inflight, 0)
+	time.Sleep(w.delay)
+	return w.syncBuffer.Write(p)
+}
+
+// stallWriter stalled 时 Write 阻塞到 release
+type stallWriter struct {
+	syncBuffer
+	mu      sync.Mutex
+	stalled bool
+	release chan struct{}
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	stalled := w.stalled
+	w.mu.Unlock()
+	if stalled {
+		<-w.release
+	}
+	return w.syncBuffer.Write(p)
+}
+
+func (w *stallWriter) recover() {
+	w.mu.Lock()
+	w.stalled = false
+	w.mu.Unlock()
+	close(w.release)
+}
+
+func TestBreakerFailover(t *testing.T) {
+	remote := &stallWriter{stalled: true, release: make(chan struct{})}
+	local := &syncBuffer{}
+	errOut := &syncBuffer{}
+	var transitions []string
+	breaker := NewBreakerWriter(remote, BreakerConfig{
+		Name:             "remote",
+		Timeout:          20 * time.Millisecond,
+		FailureThreshold: 2,
+		OpenDuration:     50 * time.Millisecond,
+		ErrorOutput:      errOut,
+		OnStateChange: func(name string, from, to BreakerState) {
+			transitions = append(transitions, from.String()+">"+to.String())
+		},
+	})
+	chain := NewFailoverWriter(errOut, breaker, local)
+	l := NewZapLoggerWithOptions(nil, WithFallbackWriters(chain))
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		l.Info("during outage")
+	}
+	// 两次超时后熔断 (第二次因第一次还卡着直接超时), 其余直接跳过远端
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("logging stalled for %v", elapsed)
+	}
+	stats := breaker.Stats()
+	if stats.State != BreakerOpen || stats.Opens != 1 || stats.Timeouts != 2 || stats.Rejected != 3 {
+		t.Fatalf("stats %+v", stats)
+	}
+	if n := strings.Count(local.String(), "during outage"); n != 5 || chain.Active() != 1 {
+		t.Fatalf("local got %d lines, active %d", n, chain.Active())
+	}
+
+	remote.recover()
+	time.Sleep(60 * time.Millisecond)
+	if breaker.State() != BreakerHalfOpen {
+		t.Fatalf("state %s", breaker.State())
+	}
+	l.Info("recovered")
+	if breaker.State() != BreakerClosed || chain.Active() != 0 || chain.Switches() != 2 {
+		t.Fatalf("state %s, active %d, switches %d", breaker.State(), chain.Active(), chain.Switches())
+	}
+	if !strings.Contains(remote.String(), "recovered") {
+		t.Fatalf("remote %q", remote.String())
+	}
+	if got := strings.Join(transitions, ","); got != "closed>open,open>half-open,half-open>closed" {
+		t.Fatalf("transitions %s", got)
+	}
+	if out := errOut.String(); !strings.Contains(out, `breaker "remote" closed -> open after 2 failures`) || !strings.Contains(out, "failover writer 0 -> 1") {
+		t.Fatalf("error output %q", out)
+	}
+}
+
+func TestBreakerConcurrentWriters(t *testing.T) {
+	remote := &slowWriter{delay: 100 * time.Microsecond}
+	breaker := NewBreakerWriter(remote, BreakerConfig{Name: "remote", Timeout: time.Second, FailureThreshold: 2, ErrorOutput: &syncBuffer{}})
+
+	var wg sync.WaitGroup
+	var failed int32
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if _, err := breaker.Write([]byte("line\n")); err != nil {
+					atomic.AddInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	stats := breaker.Stats()
+	if failed != 0 || stats.State != BreakerClosed || stats.Failures != 0 || stats.Timeouts != 0 {
+		t.Fatalf("failed %d, stats %+v", failed, stats)
+	}
+	if n := strings.Count(remote.String(), "line"); n != 400 {
+		t.Fatalf("remote got %d lines", n)
+	}
+}
@@ -0,0 +1,383 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// ElasticFields 文档里各固定字段的 key
+type ElasticFields struct {
+	TimeKey       string
+	LevelKey      string
+	MessageKey    string
+	CallerKey     string
+	NameKey       string
+	StacktraceKey string
+}
+
+var (
+	// ElasticFieldsDefault 与 NewJSONLogger / NewZapLogger 输出的 key 一致
+	ElasticFieldsDefault = ElasticFields{
+		TimeKey:       "time",
+		LevelKey:      "level",
+		MessageKey:    "msg",
+		CallerKey:     "caller",
+		NameKey:       "logger",
+		StacktraceKey: "stacktrace",
+	}
+	// ElasticFieldsLegacy 与 logger.go 的 Init/InitWithConfig 输出的 key 一致
+	ElasticFieldsLegacy = ElasticFields{
+		TimeKey:       "date",
+		LevelKey:      "level",
+		MessageKey:    "msg",
+		CallerKey:     "source",
+		NameKey:       "name",
+		StacktraceKey: "stacktrace",
+	}
+)
+
+// EncoderConfig 按这组 key 生成的 JSON encoder 配置, 时间为 ISO8601
+func (f ElasticFields) EncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:        f.TimeKey,
+		LevelKey:       f.LevelKey,
+		NameKey:        f.NameKey,
+		CallerKey:      f.CallerKey,
+		MessageKey:     f.MessageKey,
+		StacktraceKey:  f.StacktraceKey,
+		LineEnding:     "\n",
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+		EncodeDuration: zapcore.SecondsDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	}
+}
+
+// IndexTemplate composable index template 的请求体, 可 PUT 到 _index_template/<name>.
+// 时间为 date (同时接受 epoch_millis, 兼容 NewLogger 的毫秒时间戳), level/caller/logger 为 keyword
+func (f ElasticFields) IndexTemplate(patterns ...string) ([]byte, error) {
+	props := map[string]interface{}{}
+	set := func(key string, mapping map[string]interface{}) {
+		if key != "" {
+			props[key] = mapping
+		}
+	}
+	keyword := map[string]interface{}{"type": "keyword"}
+	set(f.TimeKey, map[string]interface{}{"type": "date", "format": "strict_date_optional_time||epoch_millis"})
+	set(f.LevelKey, keyword)
+	set(f.CallerKey, keyword)
+	set(f.NameKey, keyword)
+	set(f.MessageKey, map[string]interface{}{"type": "text"})
+	set(f.StacktraceKey, map[string]interface{}{"type": "text", "index": false})
+	return stdjson.Marshal(map[string]interface{}{
+		"index_patterns": patterns,
+		"template": map[string]interface{}{
+			"mappings": map[string]interface{}{"properties": props},
+		},
+	})
+}
+
+// ElasticConfig NewElasticCore 的配置, 同样适用于 OpenSearch
+type ElasticConfig struct {
+	URL string // 如 http://127.0.0.1:9200
+	// Index 索引名前缀, 默认 "logs", 实际索引为 Index + "-" + 日志时间 (UTC) 按 IndexDateLayout 格式化
+	Index           string
+	IndexDateLayout string        // 默认 "2006.01.02", "-" 表示不加日期
+	OpType          string        // index / create, 写 data stream 时用 create, 默认 index
+	Fields          ElasticFields // 默认 ElasticFieldsDefault
+	Username        string
+	Password        string
+	APIKey          string // Authorization: ApiKey <APIKey>
+	Headers         map[string]string
+	BatchSize       int           // 攒够多少条发送, 默认 500
+	FlushInterval   time.Duration // 默认 1s
+	QueueSize       int           // 等待发送的满批次数, 超过时丢弃新的批次, 默认 DefaultSinkQueueSize
+	MaxRetries      int           // 请求失败或单条 429/5xx 的重试次数, 默认 3, 负数不重试
+	MinBackoff      time.Duration // 默认 500ms, 每次翻倍
+	MaxBackoff      time.Duration // 默认 5s
+	Timeout         time.Duration // 单次请求超时, 默认 10s
+	Client          *http.Client
+	// DeadLetter 无法写入的文档每条一行 JSON: {"index", "status", "error", "doc"},
+	// 为 nil 时失败只通过 Sync 返回的 error 报告
+	DeadLetter zapcore.WriteSyncer
+	// Encoder 文档的编码, 默认按 Fields 生成的 JSON encoder
+	Encoder zapcore.Encoder
+}
+
+type elasticCore struct {
+	zapcore.LevelEnabler
+	enc    zapcore.Encoder
+	client *elasticClient
+}
+
+// NewElasticCore 通过 _bulk API 批量写入 Elasticsearch, 返回的 core 实现 io.Closer,
+// Close 发送剩余日志并停止定时发送
+func NewElasticCore(cfg ElasticConfig, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("log: elasticsearch url is empty")
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	if cfg.Index == "" {
+		cfg.Index = "logs"
+	}
+	if cfg.IndexDateLayout == "" {
+		cfg.IndexDateLayout = "2006.01.02"
+	}
+	switch cfg.OpType {
+	case "":
+		cfg.OpType = "index"
+	case "index", "create":
+	default:
+		return nil, fmt.Errorf("log: unsupported elasticsearch op_type %q", cfg.OpType)
+	}
+	if cfg.Fields == (ElasticFields{}) {
+		cfg.Fields = ElasticFieldsDefault
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultSinkQueueSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 5 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if cfg.Encoder == nil {
+		cfg.Encoder = zapcore.NewJSONEncoder(cfg.Fields.EncoderConfig())
+	}
+
+	client := &elasticClient{cfg: &cfg}
+	client.batch = newBatcher(batcherConfig{
+		MaxItems:  cfg.BatchSize,
+		QueueSize: cfg.QueueSize,
+		Interval:  cfg.FlushInterval,
+	}, client.send)
+	return &elasticCore{LevelEnabler: enab, enc: cfg.Encoder, client: client}, nil
+}
+
+func (c *elasticCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for i := range fields {
+		fields[i].AddTo(clone.enc)
+	}
+	return &clone
+}
+
+func (c *elasticCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *elasticCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	doc := elasticDoc{
+		index: c.client.indexName(ent.Time),
+		body:  append([]byte(nil), bytes.TrimRight(buf.Bytes(), "\n")...),
+	}
+	buf.Free()
+	return c.client.batch.add(doc, 0)
+}
+
+func (c *elasticCore) Sync() error {
+	return c.client.batch.flush()
+}
+
+func (c *elasticCore) Close() error {
+	return c.client.batch.close()
+}
+
+type elasticDoc struct {
+	index string
+	body  []byte
+}
+
+type elasticClient struct {
+	cfg   *ElasticConfig
+	batch *batcher
+}
+
+func (cl *elasticClient) indexName(t time.Time) string {
+	if cl.cfg.IndexDateLayout == "-" {
+		return cl.cfg.Index
+	}
+	return cl.cfg.Index + "-" + t.UTC().Format(cl.cfg.IndexDateLayout)
+}
+
+// send 发送一个批次, 只重试失败的文档, 最终失败的写入 DeadLetter
+func (cl *elasticClient) send(ctx context.Context, items []interface{}) error {
+	docs := make([]elasticDoc, len(items))
+	for i, item := range items {
+		docs[i] = item.(elasticDoc)
+	}
+
+	var failed []elasticFailure
+	policy := retryPolicy{maxRetries: cl.cfg.MaxRetries, minBackoff: cl.cfg.MinBackoff, maxBackoff: cl.cfg.MaxBackoff}
+	if err := policy.do(ctx, func() (bool, time.Duration, error) {
+		var retry []elasticDoc
+		if retry, failed = cl.bulk(ctx, docs, failed); len(retry) == 0 {
+			return false, 0, nil
+		}
+		docs = retry
+		return true, 0, errElasticRetry
+	}); err != nil {
+		for _, doc := range docs {
+			failed = append(failed, elasticFailure{doc: doc, reason: "retries exhausted"})
+		}
+	}
+	return cl.deadLetter(failed)
+}
+
+// errElasticRetry 还有文档需要重试
+var errElasticRetry = errors.New("log: elasticsearch bulk: retry")
+
+type elasticFailure struct {
+	doc    elasticDoc
+	status int
+	reason string
+}
+
+// elasticAction _bulk 的 action 行, 如 {"index":{"_index":"logs-2024.01.02"}}
+type elasticAction struct {
+	Index string `json:"_index"`
+}
+
+type elasticBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int                `json:"status"`
+		Error  stdjson.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// bulk 发送一次 _bulk 请求, 返回需要重试的文档, 不可重试的追加到 failed
+func (cl *elasticClient) bulk(ctx context.Context, docs []elasticDoc, failed []elasticFailure) ([]elasticDoc, []elasticFailure) {
+	var body bytes.Buffer
+	for _, doc := range docs {
+		action, _ := stdjson.Marshal(map[string]elasticAction{cl.cfg.OpType: {Index: doc.index}})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc.body)
+		body.WriteByte('\n')
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cl.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cl.cfg.URL+"/_bulk", &body)
+	if err != nil {
+		for _, doc := range docs {
+			failed = append(failed, elasticFailure{doc: doc, reason: err.Error()})
+		}
+		return nil, failed
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if cl.cfg.Username != "" {
+		req.SetBasicAuth(cl.cfg.Username, cl.cfg.Password)
+	}
+	if cl.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+cl.cfg.APIKey)
+	}
+	for k, v := range cl.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := cl.cfg.Client.Do(req)
+	if err != nil {
+		return docs, failed
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return docs, failed
+	case resp.StatusCode/100 != 2:
+		reason := fmt.Sprintf("status %d: %s", resp.StatusCode, bytes.TrimSpace(data))
+		for _, doc := range docs {
+			failed = append(failed, elasticFailure{doc: doc, status: resp.StatusCode, reason: reason})
+		}
+		return nil, failed
+	}
+
+	var result elasticBulkResponse
+	if err := stdjson.Unmarshal(data, &result); err != nil || len(result.Items) != len(docs) {
+		reason := fmt.Sprintf("invalid bulk response: %.256s", data)
+		for _, doc := range docs {
+			failed = append(failed, elasticFailure{doc: doc, status: resp.StatusCode, reason: reason})
+		}
+		return nil, failed
+	}
+	if !result.Errors {
+		return nil, failed
+	}
+	var retry []elasticDoc
+	for i, item := range result.Items {
+		// 每个 item 只有一个 key, 即 op_type
+		for _, r := range item {
+			switch {
+			case r.Status/100 == 2:
+			case r.Status == http.StatusTooManyRequests || r.Status >= 500:
+				retry = append(retry, docs[i])
+			default:
+				failed = append(failed, elasticFailure{doc: docs[i], status: r.Status, reason: string(r.Error)})
+			}
+		}
+	}
+	return retry, failed
+}
+
+func (cl *elasticClient) deadLetter(failed []elasticFailure) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("log: elasticsearch bulk: %d docs failed, first: %s", len(failed), failed[0].reason)
+	if cl.cfg.DeadLetter == nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, f := range failed {
+		line, merr := stdjson.Marshal(struct {
+			Index  string             `json:"index"`
+			Status int                `json:"status,omitempty"`
+			Error  string             `json:"error"`
+			Doc    stdjson.RawMessage `json:"doc"`
+		}{f.doc.index, f.status, f.reason, f.doc.body})
+		if merr != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if _, werr := cl.cfg.DeadLetter.Write(buf.Bytes()); werr != nil {
+		return err
+	}
+	_ = cl.cfg.DeadLetter.Sync()
+	return nil
+}
@@ -0,0 +1,149 @@
+package log
+
+import (
+	"bufio"
+	stdjson "encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// elasticBulkServer 按 msg 决定每条文档的结果: "busy" 第一次返回 429, "bad" 返回 400
+type elasticBulkServer struct {
+	mu      sync.Mutex
+	busy    bool
+	indexes []string
+	msgs    []string
+}
+
+func (s *elasticBulkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if user, pass, _ := r.BasicAuth(); user != "elastic" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []string
+	hasErrors := false
+	sc := bufio.NewScanner(r.Body)
+	for sc.Scan() {
+		var action map[string]struct {
+			Index string `json:"_index"`
+		}
+		_ = stdjson.Unmarshal(sc.Bytes(), &action)
+		sc.Scan()
+		var doc map[string]interface{}
+		_ = stdjson.Unmarshal(sc.Bytes(), &doc)
+
+		status := 201
+		switch doc["msg"] {
+		case "busy":
+			if !s.busy {
+				s.busy = true
+				status = 429
+			}
+		case "bad":
+			status = 400
+		}
+		if status == 201 {
+			s.indexes = append(s.indexes, action["index"].Index)
+			s.msgs = append(s.msgs, doc["msg"].(string))
+			items = append(items, `{"index":{"status":201}}`)
+		} else {
+			hasErrors = true
+			items = append(items, fmt.Sprintf(`{"index":{"status":%d,"error":{"type":"mapper_parsing_exception"}}}`, status))
+		}
+	}
+	fmt.Fprintf(w, `{"errors":%t,"items":[%s]}`, hasErrors, strings.Join(items, ","))
+}
+
+func TestElasticBulk(t *testing.T) {
+	es := &elasticBulkServer{}
+	srv := httptest.NewServer(es)
+	defer srv.Close()
+
+	deadLetter := &syncBuffer{}
+	core, err := NewElasticCore(ElasticConfig{
+		URL:           srv.URL,
+		Index:         "app",
+		Fields:        ElasticFieldsLegacy,
+		Username:      "elastic",
+		Password:      "secret",
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+		MinBackoff:    time.Millisecond,
+		DeadLetter:    deadLetter,
+	}, zapcore.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.(io.Closer).Close()
+	logger := zap.New(core, zap.AddCaller())
+	logger.Info("ok")
+	logger.Info("busy")
+	logger.Info("bad", zap.Int("n", 1))
+	// 满批在后台发送, Sync 等待它完成
+	if err := logger.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if strings.Join(es.msgs, ",") != "ok,busy" {
+		t.Fatalf("indexed %v", es.msgs)
+	}
+	if want := "app-" + time.Now().UTC().Format("2006.01.02"); es.indexes[0] != want {
+		t.Fatalf("index %q, want %q", es.indexes[0], want)
+	}
+
+	var dead struct {
+		Index  string                 `json:"index"`
+		Status int                    `json:"status"`
+		Error  string                 `json:"error"`
+		Doc    map[string]interface{} `json:"doc"`
+	}
+	if err := stdjson.Unmarshal([]byte(deadLetter.String()), &dead); err != nil {
+		t.Fatalf("dead letter %q: %v", deadLetter.String(), err)
+	}
+	if dead.Status != 400 || !strings.Contains(dead.Error, "mapper_parsing_exception") || dead.Doc["msg"] != "bad" {
+		t.Fatalf("dead letter %+v", dead)
+	}
+	// logger.go 的字段名
+	if dead.Doc["date"] == nil || dead.Doc["source"] == nil || dead.Doc["level"] != "info" {
+		t.Fatalf("doc keys %v", dead.Doc)
+	}
+}
+
+func TestElasticIndexTemplate(t *testing.T) {
+	data, err := ElasticFieldsLegacy.IndexTemplate("app-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tpl struct {
+		Template struct {
+			Mappings struct {
+				Properties map[string]map[string]interface{} `json:"properties"`
+			} `json:"mappings"`
+		} `json:"template"`
+	}
+	if err := stdjson.Unmarshal(data, &tpl); err != nil {
+		t.Fatal(err)
+	}
+	props := tpl.Template.Mappings.Properties
+	if props["date"]["type"] != "date" || props["source"]["type"] != "keyword" || props["level"]["type"] != "keyword" {
+		t.Fatalf("mapping %s", data)
+	}
+}
@@ -0,0 +1,261 @@
+package log
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// EmailConfig NewEmailCore 的配置
+type EmailConfig struct {
+	Addr string // SMTP 服务器 host:port
+	From string
+	To   []string
+	// Username 不为空时使用 PLAIN 认证, 非本机的服务器要求 TLS
+	Username string
+	Password string
+	// StartTLS 服务器支持时总会升级, 为 true 时不支持则报错
+	StartTLS  bool
+	TLSConfig *tls.Config // 默认 ServerName 为 Addr 的 host
+	Subject   string      // 默认 "[LEVEL] 主机名: msg"
+	// Level 发送邮件的最低等级, 低于 WarnLevel (包括零值) 时为 DPanicLevel
+	Level zapcore.Level
+	// ContextLines 邮件中附带的最近日志行数, 默认 50, 负数不附带
+	ContextLines int
+	// Timeout 连接到发送完成的总超时, 默认 10s. Fatal 之后进程退出, 邮件只能同步发送
+	Timeout time.Duration
+	// Encoder 最近日志行的编码, 默认 JSON
+	Encoder zapcore.Encoder
+}
+
+// NewEmailCore 等级达到 cfg.Level 的日志同步发送邮件, 附带堆栈和最近的日志.
+// enab 控制哪些等级进入最近日志的环形缓冲, 如 zapcore.DebugLevel
+func NewEmailCore(cfg EmailConfig, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("log: invalid smtp addr %q: %w", cfg.Addr, err)
+	}
+	if cfg.From == "" || len(cfg.To) == 0 {
+		return nil, errors.New("log: email from and to are required")
+	}
+	if cfg.Level < zapcore.WarnLevel {
+		cfg.Level = zapcore.DPanicLevel
+	}
+	if cfg.ContextLines == 0 {
+		cfg.ContextLines = 50
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.TLSConfig == nil {
+		cfg.TLSConfig = &tls.Config{ServerName: host}
+	}
+	if cfg.Encoder == nil {
+		encCfg := zap.NewProductionEncoderConfig()
+		encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+		encCfg.StacktraceKey = "" // 堆栈单独放在邮件正文
+		cfg.Encoder = zapcore.NewJSONEncoder(encCfg)
+	}
+	c := &emailCore{LevelEnabler: enab, enc: cfg.Encoder, cfg: &cfg, host: host}
+	if cfg.ContextLines > 0 {
+		c.ring = &lineRing{lines: make([]string, cfg.ContextLines)}
+	}
+	return c, nil
+}
+
+type emailCore struct {
+	zapcore.LevelEnabler
+	enc  zapcore.Encoder
+	cfg  *EmailConfig
+	host string
+	ring *lineRing // With 之后共用
+}
+
+func (c *emailCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= c.cfg.Level || c.LevelEnabler.Enabled(lvl)
+}
+
+func (c *emailCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for i := range fields {
+		fields[i].AddTo(clone.enc)
+	}
+	return &clone
+}
+
+func (c *emailCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *emailCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	line := strings.TrimRight(buf.String(), "\n")
+	buf.Free()
+	// 先取最近的日志再放入当前这条
+	var recent []string
+	if c.ring != nil {
+		if ent.Level >= c.cfg.Level {
+			recent = c.ring.snapshot()
+		}
+		if c.LevelEnabler.Enabled(ent.Level) {
+			c.ring.add(line)
+		}
+	}
+	if ent.Level < c.cfg.Level {
+		return nil
+	}
+	if ent.Stack == "" {
+		ent.Stack = zap.StackSkip("", 0).String
+	}
+	return c.send(ent, line, recent)
+}
+
+func (c *emailCore) Sync() error {
+	return nil
+}
+
+func (c *emailCore) send(ent zapcore.Entry, line string, recent []string) error {
+	if err := c.deliver(c.message(ent, line, recent)); err != nil {
+		return fmt.Errorf("log: send email: %w", err)
+	}
+	return nil
+}
+
+func (c *emailCore) message(ent zapcore.Entry, line string, recent []string) []byte {
+	cfg := c.cfg
+	subject := cfg.Subject
+	if subject == "" {
+		hostname, _ := os.Hostname()
+		subject = fmt.Sprintf("[%s] %s: %s", ent.Level.CapitalString(), hostname, ent.Message)
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\n\n", line)
+	fmt.Fprintf(&body, "level:  %s\ntime:   %s\n", ent.Level.CapitalString(), ent.Time.Format(time.RFC3339Nano))
+	if ent.LoggerName != "" {
+		fmt.Fprintf(&body, "logger: %s\n", ent.LoggerName)
+	}
+	if ent.Caller.Defined {
+		fmt.Fprintf(&body, "caller: %s\n", ent.Caller.String())
+	}
+	fmt.Fprintf(&body, "\nstacktrace:\n%s\n", ent.Stack)
+	if len(recent) > 0 {
+		fmt.Fprintf(&body, "\nlast %d lines:\n%s\n", len(recent), strings.Join(recent, "\n"))
+	}
+	// SMTP 要求 CRLF, 行首的 "." 由 smtp 包的 DATA writer 处理
+	b.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// deliver 与 smtp.SendMail 相同的流程, 整个会话受 Timeout 限制
+func (c *emailCore) deliver(msg []byte) (err error) {
+	cfg := c.cfg
+	deadline := time.Now().Add(cfg.Timeout)
+	conn, err := net.DialTimeout("tcp", cfg.Addr, cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, c.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer func() {
+		// Quit 成功时已经关闭连接
+		if err != nil {
+			client.Close()
+		}
+	}()
+
+	if err := client.Hello("localhost"); err != nil {
+		return err
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(cfg.TLSConfig); err != nil {
+			return err
+		}
+	} else if cfg.StartTLS {
+		return errors.New("server does not support STARTTLS")
+	}
+	if cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, c.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// lineRing 最近 n 行日志
+type lineRing struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func (r *lineRing) add(line string) {
+	r.mu.Lock()
+	r.lines[r.next] = line
+	if r.next++; r.next == len(r.lines) {
+		r.next, r.full = 0, true
+	}
+	r.mu.Unlock()
+}
+
+// snapshot 按时间顺序返回
+func (r *lineRing) snapshot() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	ret := make([]string, 0, len(r.lines))
+	ret = append(ret, r.lines[r.next:]...)
+	return append(ret, r.lines[:r.next]...)
+}
@@ -0,0 +1,163 @@
+package log
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// fakeSMTP 只实现 EHLO, AUTH PLAIN, MAIL, RCPT, DATA, QUIT
+type fakeSMTP struct {
+	ln    net.Listener
+	stall bool // 不发送问候语
+
+	mu    sync.Mutex
+	auth  []string
+	rcpt  []string
+	mails []string
+}
+
+func newFakeSMTP(t *testing.T, stall bool) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln, stall: stall}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	if s.stall {
+		time.Sleep(time.Second)
+		return
+	}
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		s.mu.Lock()
+		switch cmd {
+		case "EHLO":
+			reply("250-fake\r\n250 AUTH PLAIN")
+		case "AUTH":
+			data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+			s.auth = append(s.auth, string(data))
+			reply("235 ok")
+		case "MAIL":
+			reply("250 ok")
+		case "RCPT":
+			s.rcpt = append(s.rcpt, line)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var mail strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				mail.WriteString(l)
+			}
+			s.mails = append(s.mails, mail.String())
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			s.mu.Unlock()
+			return
+		default:
+			reply("502 unknown")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestEmailCore(t *testing.T) {
+	server := newFakeSMTP(t, false)
+	core, err := NewEmailCore(EmailConfig{
+		Addr:         server.ln.Addr().String(),
+		From:         "app@example.com",
+		To:           []string{"oncall@example.com", "dev@example.com"},
+		Username:     "user",
+		Password:     "pass",
+		ContextLines: 2,
+		Timeout:      time.Second,
+	}, zapcore.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(core, zap.AddCaller())
+	logger.Debug("step 1")
+	logger.Info("step 2")
+	logger.Warn("step 3")
+	logger.Error("not mailed")
+	func() {
+		defer func() { recover() }()
+		logger.Panic("out of memory", zap.String("order", "42"))
+	}()
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.mails) != 1 || len(server.rcpt) != 2 || len(server.auth) != 1 || server.auth[0] != "\x00user\x00pass" {
+		t.Fatalf("mails %d, rcpt %q, auth %q", len(server.mails), server.rcpt, server.auth)
+	}
+	mail := server.mails[0]
+	for _, want := range []string{
+		"Subject: [PANIC] ",
+		"To: oncall@example.com, dev@example.com\r\n",
+		`"msg":"out of memory","order":"42"`,
+		"stacktrace:\r\n",
+		"TestEmailCore",
+		"last 2 lines:\r\n",
+		`"msg":"step 3"`,
+		`"msg":"not mailed"`,
+	} {
+		if !strings.Contains(mail, want) {
+			t.Errorf("missing %q in\n%s", want, mail)
+		}
+	}
+	if strings.Contains(mail, "step 2") {
+		t.Errorf("ring buffer kept too many lines:\n%s", mail)
+	}
+}
+
+func TestEmailCoreTimeout(t *testing.T) {
+	server := newFakeSMTP(t, true)
+	core, err := NewEmailCore(EmailConfig{
+		Addr:    server.ln.Addr().String(),
+		From:    "app@example.com",
+		To:      []string{"oncall@example.com"},
+		Timeout: 100 * time.Millisecond,
+	}, zapcore.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err = core.Write(zapcore.Entry{Level: zapcore.FatalLevel, Message: "bye"}, nil)
+	if err == nil || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("err %v after %v", err, time.Since(start))
+	}
+}
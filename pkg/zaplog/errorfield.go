@@ -0,0 +1,127 @@
+package log
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// 防止错误链成环
+const maxErrorDepth = 16
+
+// errorFielder errors carrying their own typed fields
+type errorFielder interface {
+	Fields() []zap.Field
+}
+
+// errorMetaer errors carrying metas, same as WrapMeta
+type errorMetaer interface {
+	Meta() []Meta
+}
+
+// ErrorObject encode err as one nested "error" object:
+// message, type, stack, fields and causes (walks Unwrap() error and Unwrap() []error)
+func ErrorObject(err error) zap.Field {
+	if err == nil {
+		return zap.Skip()
+	}
+	return zap.Object("error", errorObject{err: err})
+}
+
+type errorObject struct {
+	err   error
+	depth int
+}
+
+func (e errorObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("message", e.err.Error())
+	enc.AddString("type", fmt.Sprintf("%T", e.err))
+	if stack := errorStack(e.err); stack != "" {
+		enc.AddString("stack", stack)
+	}
+
+	var fields []zap.Field
+	if f, ok := e.err.(errorFielder); ok {
+		fields = append(fields, f.Fields()...)
+	}
+	if m, ok := e.err.(errorMetaer); ok {
+		for _, meta := range m.Meta() {
+			fields = append(fields, meta.field())
+		}
+	}
+	if len(fields) > 0 {
+		if err := enc.AddObject("fields", fieldsObject(fields)); err != nil {
+			return err
+		}
+	}
+
+	causes := unwrapErrors(e.err)
+	if len(causes) == 0 || e.depth >= maxErrorDepth {
+		return nil
+	}
+	return enc.AddArray("causes", errorArray{errs: causes, depth: e.depth + 1})
+}
+
+type errorArray struct {
+	errs  []error
+	depth int
+}
+
+func (a errorArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, err := range a.errs {
+		if err := enc.AppendObject(errorObject{err: err, depth: a.depth}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type fieldsObject []zap.Field
+
+func (fs fieldsObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for i := range fs {
+		fs[i].AddTo(enc)
+	}
+	return nil
+}
+
+func unwrapErrors(err error) []error {
+	var causes []error
+	switch u := err.(type) {
+	case interface{ Unwrap() []error }:
+		causes = u.Unwrap()
+	case interface{ Unwrap() error }:
+		causes = []error{u.Unwrap()}
+	}
+	ret := causes[:0:0]
+	for _, cause := range causes {
+		if cause != nil {
+			ret = append(ret, cause)
+		}
+	}
+	return ret
+}
+
+// errorStack 支持 Stack() []byte / Stack() string / StackTrace() string,
+// 以及 github.com/pkg/errors 风格的 StackTrace() 返回值用 %+v 格式化
+func errorStack(err error) string {
+	switch s := err.(type) {
+	case interface{ Stack() []byte }:
+		return string(s.Stack())
+	case interface{ Stack() string }:
+		return s.Stack()
+	case interface{ StackTrace() string }:
+		return s.StackTrace()
+	}
+	method := reflect.ValueOf(err).MethodByName("StackTrace")
+	if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 {
+		return ""
+	}
+	stack := fmt.Sprintf("%+v", method.Call(nil)[0].Interface())
+	if len(stack) > 0 && stack[0] == '\n' {
+		stack = stack[1:]
+	}
+	return stack
+}
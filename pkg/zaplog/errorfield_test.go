@@ -0,0 +1,57 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type joinErr []error
+
+func (e joinErr) Error() string   { return "joined" }
+func (e joinErr) Unwrap() []error { return e }
+
+type stackErr struct{ msg string }
+
+func (e *stackErr) Error() string       { return e.msg }
+func (e *stackErr) Stack() string       { return "main.go:10" }
+func (e *stackErr) Fields() []zap.Field { return []zap.Field{zap.Int("code", 404)} }
+func (e *stackErr) Meta() []Meta        { return []Meta{NewMeta("user", "u1")} }
+
+func TestWrapMetaErrorObject(t *testing.T) {
+	base := &stackErr{msg: "not found"}
+	err := fmt.Errorf("load: %w", joinErr{base, errors.New("timeout")})
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range WrapMeta(err, NewMeta("req", "r1")) {
+		f.AddTo(enc)
+	}
+
+	obj, ok := enc.Fields["error"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("error field = %#v", enc.Fields["error"])
+	}
+	if obj["message"] != "load: joined" || !strings.Contains(obj["type"].(string), "wrapError") {
+		t.Fatalf("top error = %#v", obj)
+	}
+	joined := obj["causes"].([]interface{})[0].(map[string]interface{})
+	causes := joined["causes"].([]interface{})
+	if len(causes) != 2 {
+		t.Fatalf("joined causes = %#v", causes)
+	}
+	first := causes[0].(map[string]interface{})
+	if first["stack"] != "main.go:10" || first["type"] != "*log.stackErr" {
+		t.Fatalf("first cause = %#v", first)
+	}
+	fields := first["fields"].(map[string]interface{})
+	if fields["code"] != int64(404) || fields["user"] != "u1" {
+		t.Fatalf("cause fields = %#v", fields)
+	}
+	if meta := enc.Fields["meta"].(map[string]interface{}); meta["req"] != "r1" {
+		t.Fatalf("meta = %#v", meta)
+	}
+}
@@ -0,0 +1,352 @@
+package log
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	stdjson "encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultErrorGroups 包级的 ErrorGroups, InitWithRotation 传入 InitWithErrorGroups(DefaultErrorGroups) 后
+// ErrorJson 等函数的错误可以从它查看
+var DefaultErrorGroups = NewErrorGroups(ErrorGroupsConfig{})
+
+// ErrorGroupsConfig NewErrorGroups 的配置
+type ErrorGroupsConfig struct {
+	Level     zapcore.Level // 统计的最低等级, 低于 WarnLevel (包括零值) 时为 ErrorLevel
+	MaxGroups int           // 最多保留多少组, 超过时淘汰最久没出现的, 默认 1000
+}
+
+// ErrorGroup 指纹相同的一组错误
+type ErrorGroup struct {
+	Fingerprint string      `json:"fingerprint"`
+	Message     string      `json:"message"` // 去掉数字和 ID 后的消息
+	Caller      string      `json:"caller,omitempty"`
+	ErrorType   string      `json:"error_type,omitempty"`
+	Level       string      `json:"level"` // 出现过的最高等级
+	Count       uint64      `json:"count"`
+	FirstSeen   time.Time   `json:"first_seen"`
+	LastSeen    time.Time   `json:"last_seen"`
+	Sample      ErrorSample `json:"sample"` // 第一条
+}
+
+// ErrorSample 一组错误中的一条原始日志
+type ErrorSample struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Logger  string                 `json:"logger,omitempty"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// ErrorGroups 按指纹 (归一化的消息 + caller + 错误类型) 聚合错误日志, 类似本地的 Sentry.
+// 通过 WithErrorGroups, Option 或 Core 接入 logger, ServeHTTP 输出 JSON
+type ErrorGroups struct {
+	cfg ErrorGroupsConfig
+
+	mu       sync.Mutex
+	groups   map[string]*errorGroup
+	total    uint64 // 全部错误条数, 用于计算两次汇总之间的增量
+	summary  map[string]uint64
+	prevSeen uint64
+}
+
+type errorGroup struct {
+	ErrorGroup
+	level zapcore.Level
+}
+
+func NewErrorGroups(cfg ErrorGroupsConfig) *ErrorGroups {
+	if cfg.Level < zapcore.WarnLevel {
+		cfg.Level = zapcore.ErrorLevel
+	}
+	if cfg.MaxGroups <= 0 {
+		cfg.MaxGroups = 1000
+	}
+	return &ErrorGroups{cfg: cfg, groups: make(map[string]*errorGroup)}
+}
+
+// Core 与其他 core 一起使用, 如 WithExtraCores(g.Core())
+func (g *ErrorGroups) Core() zapcore.Core {
+	return &errorGroupCore{groups: g}
+}
+
+// Option 用于已有的 *zap.Logger: logger.WithOptions(g.Option())
+func (g *ErrorGroups) Option() zap.Option {
+	return zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(c, g.Core())
+	})
+}
+
+var (
+	errorUUIDRe   = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	errorHexRe    = regexp.MustCompile(`\b(0[xX][0-9a-fA-F]+|[0-9a-fA-F]{8,})\b`)
+	errorNumberRe = regexp.MustCompile(`\d+(\.\d+)?`)
+)
+
+// NormalizeErrorMessage 把 UUID, 十六进制 ID 和数字替换成占位符, 如
+// "order 123 not found (req 9f2c4e1a...)" -> "order <n> not found (req <id>)"
+func NormalizeErrorMessage(msg string) string {
+	msg = errorUUIDRe.ReplaceAllString(msg, "<id>")
+	msg = errorHexRe.ReplaceAllStringFunc(msg, func(s string) string {
+		// 全是字母的可能是普通单词, 如 "defaced"
+		if strings.IndexAny(s, "0123456789") < 0 {
+			return s
+		}
+		return "<id>"
+	})
+	return errorNumberRe.ReplaceAllString(msg, "<n>")
+}
+
+// ErrorFingerprint 归一化的消息, caller 和错误类型的 sha1 前 16 位
+func ErrorFingerprint(msg, caller, errType string) string {
+	sum := sha1.Sum([]byte(NormalizeErrorMessage(msg) + "\x00" + caller + "\x00" + errType))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (g *ErrorGroups) observe(ent zapcore.Entry, fields []zapcore.Field) {
+	msg, errType := ent.Message, ""
+	var data string
+	for _, f := range fields {
+		switch {
+		case f.Type == zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok && errType == "" {
+				errType, data = fmt.Sprintf("%T", err), err.Error()
+			}
+		case f.Type == zapcore.ObjectMarshalerType && f.Key == "error":
+			// WrapMeta 的 ErrorObject
+			if obj, ok := f.Interface.(errorObject); ok && errType == "" {
+				errType, data = fmt.Sprintf("%T", obj.err), obj.err.Error()
+			}
+		case f.Type == zapcore.StringType && f.Key == "data":
+			// ErrorJson, Errorf 等没有 message, 内容在 data 里
+			if data == "" {
+				data = f.String
+			}
+		}
+	}
+	if msg == "" {
+		msg = data
+	}
+	var caller string
+	if ent.Caller.Defined {
+		caller = ent.Caller.TrimmedPath()
+	}
+	fp := ErrorFingerprint(msg, caller, errType)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.total++
+	if grp, ok := g.groups[fp]; ok {
+		grp.Count++
+		grp.LastSeen = ent.Time
+		if ent.Level > grp.level {
+			grp.level, grp.Level = ent.Level, ent.Level.String()
+		}
+		return
+	}
+	if len(g.groups) >= g.cfg.MaxGroups {
+		g.evict()
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	for i := range fields {
+		fields[i].AddTo(enc)
+	}
+	g.groups[fp] = &errorGroup{
+		level: ent.Level,
+		ErrorGroup: ErrorGroup{
+			Fingerprint: fp,
+			Message:     NormalizeErrorMessage(msg),
+			Caller:      caller,
+			ErrorType:   errType,
+			Level:       ent.Level.String(),
+			Count:       1,
+			FirstSeen:   ent.Time,
+			LastSeen:    ent.Time,
+			Sample: ErrorSample{
+				Time:    ent.Time,
+				Level:   ent.Level.String(),
+				Logger:  ent.LoggerName,
+				Message: ent.Message,
+				Fields:  enc.Fields,
+			},
+		},
+	}
+}
+
+// evict 淘汰最久没出现的一组, 调用时持有 mu
+func (g *ErrorGroups) evict() {
+	var oldest *errorGroup
+	for _, grp := range g.groups {
+		if oldest == nil || grp.LastSeen.Before(oldest.LastSeen) {
+			oldest = grp
+		}
+	}
+	if oldest != nil {
+		delete(g.groups, oldest.Fingerprint)
+	}
+}
+
+// Groups 按次数从多到少排列的快照
+func (g *ErrorGroups) Groups() []ErrorGroup {
+	g.mu.Lock()
+	ret := make([]ErrorGroup, 0, len(g.groups))
+	for _, grp := range g.groups {
+		ret = append(ret, grp.ErrorGroup)
+	}
+	g.mu.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Count != ret[j].Count {
+			return ret[i].Count > ret[j].Count
+		}
+		return ret[i].LastSeen.After(ret[j].LastSeen)
+	})
+	return ret
+}
+
+// Reset 清空所有分组
+func (g *ErrorGroups) Reset() {
+	g.mu.Lock()
+	g.groups = make(map[string]*errorGroup)
+	g.total, g.summary, g.prevSeen = 0, nil, 0
+	g.mu.Unlock()
+}
+
+// ServeHTTP 输出 {"total":n,"groups":[...]}, ?limit=n 只返回前 n 组, ?fingerprint=x 只返回一组
+func (g *ErrorGroups) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	groups := g.Groups()
+	if fp := r.URL.Query().Get("fingerprint"); fp != "" {
+		filtered := groups[:0]
+		for _, grp := range groups {
+			if grp.Fingerprint == fp {
+				filtered = append(filtered, grp)
+			}
+		}
+		groups = filtered
+	}
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit >= 0 && limit < len(groups) {
+		groups = groups[:limit]
+	}
+	g.mu.Lock()
+	total := g.total
+	g.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = stdjson.NewEncoder(w).Encode(struct {
+		Total  uint64       `json:"total"`
+		Groups []ErrorGroup `json:"groups"`
+	}{total, groups})
+}
+
+// errorGroupSummary 汇总日志中的一组
+type errorGroupSummary struct {
+	Fingerprint string `json:"fingerprint"`
+	Message     string `json:"message"`
+	Caller      string `json:"caller,omitempty"`
+	Count       uint64 `json:"count"` // 距离上次汇总新增的条数
+	Total       uint64 `json:"total"`
+}
+
+// StartSummary 每隔 interval 用 logger 写一条 Info 汇总, 包含这段时间新增最多的 top 组,
+// 没有新错误时不写. 返回的函数停止汇总
+func (g *ErrorGroups) StartSummary(logger *zap.Logger, interval time.Duration, top int) (stop func()) {
+	if top <= 0 {
+		top = 10
+	}
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				g.logSummary(logger, interval, top)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+func (g *ErrorGroups) logSummary(logger *zap.Logger, interval time.Duration, top int) {
+	g.mu.Lock()
+	newErrors := g.total - g.prevSeen
+	g.prevSeen = g.total
+	prev := g.summary
+	g.summary = make(map[string]uint64, len(g.groups))
+	var changed []errorGroupSummary
+	for fp, grp := range g.groups {
+		g.summary[fp] = grp.Count
+		if delta := grp.Count - prev[fp]; delta > 0 {
+			changed = append(changed, errorGroupSummary{Fingerprint: fp, Message: grp.Message, Caller: grp.Caller, Count: delta, Total: grp.Count})
+		}
+	}
+	groups := len(g.groups)
+	g.mu.Unlock()
+	if newErrors == 0 {
+		return
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		if changed[i].Count != changed[j].Count {
+			return changed[i].Count > changed[j].Count
+		}
+		return changed[i].Fingerprint < changed[j].Fingerprint
+	})
+	if len(changed) > top {
+		changed = changed[:top]
+	}
+	logger.Info("error groups summary",
+		zap.Uint64("new_errors", newErrors),
+		zap.Duration("interval", interval),
+		zap.Int("groups", groups),
+		zap.Any("top", changed))
+}
+
+type errorGroupCore struct {
+	groups *ErrorGroups
+	fields []zapcore.Field
+}
+
+func (c *errorGroupCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= c.groups.cfg.Level
+}
+
+func (c *errorGroupCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+	return &clone
+}
+
+func (c *errorGroupCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *errorGroupCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if len(c.fields) > 0 {
+		fields = append(append(make([]zapcore.Field, 0, len(c.fields)+len(fields)), c.fields...), fields...)
+	}
+	c.groups.observe(ent, fields)
+	return nil
+}
+
+func (c *errorGroupCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,140 @@
+package log
+
+import (
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type orderError struct{ id int }
+
+func (e *orderError) Error() string { return fmt.Sprintf("order %d not found", e.id) }
+
+func TestNormalizeErrorMessage(t *testing.T) {
+	for in, want := range map[string]string{
+		"order 123 not found":                                "order <n> not found",
+		"req 3f2504e0-4f89-11d3-9a0c-0305e82c3301 timeout":   "req <id> timeout",
+		"object 5f1d7c9a2b3e4f60 at 0xc000123abc is defaced": "object <id> at <id> is defaced",
+		"took 1.25s, retry 3":                                "took <n>s, retry <n>",
+	} {
+		if got := NormalizeErrorMessage(in); got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestErrorGroups(t *testing.T) {
+	groups := NewErrorGroups(ErrorGroupsConfig{MaxGroups: 3})
+	summary := &syncBuffer{}
+	l := NewZapLoggerWithOptions(nil, WithErrorGroups(groups), WithFallbackWriters(&syncBuffer{}))
+	for i := 0; i < 3; i++ {
+		l.Error("create order failed", WrapMeta(&orderError{id: i}, NewMeta("uid", i))...)
+	}
+	l.Error("create order failed", WrapMeta(errors.New("order 7 not found"))...)
+	l.Warn("ignored")
+	for i := 0; i < 2; i++ {
+		l.Error(fmt.Sprintf("user %d has no quota", 1000+i))
+	}
+
+	got := groups.Groups()
+	if len(got) != 3 {
+		t.Fatalf("groups %+v", got)
+	}
+	// 错误类型不同的 WrapMeta 分在两组
+	if got[0].Count != 3 || got[0].ErrorType != "*log.orderError" || got[0].Message != "create order failed" ||
+		fmt.Sprint(got[0].Sample.Fields["meta"].(map[string]interface{})["uid"]) != "0" {
+		t.Fatalf("first group %+v", got[0])
+	}
+	if got[1].Count != 2 || got[1].Message != "user <n> has no quota" || got[1].Sample.Message != "user 1000 has no quota" {
+		t.Fatalf("second group %+v", got[1])
+	}
+	if got[2].ErrorType != "*errors.errorString" || got[0].Fingerprint == got[2].Fingerprint {
+		t.Fatalf("third group %+v", got[2])
+	}
+
+	rec := httptest.NewRecorder()
+	groups.ServeHTTP(rec, httptest.NewRequest("GET", "/errors?limit=1", nil))
+	var resp struct {
+		Total  uint64
+		Groups []ErrorGroup
+	}
+	if err := stdjson.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Total != 6 || len(resp.Groups) != 1 || resp.Groups[0].Fingerprint != got[0].Fingerprint {
+		t.Fatalf("response %s: %v", rec.Body.String(), err)
+	}
+
+	// 超过 MaxGroups 淘汰最久没出现的
+	l.Error("new kind of failure")
+	if n := len(groups.Groups()); n != 3 {
+		t.Fatalf("%d groups after eviction", n)
+	}
+
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), summary, zapcore.InfoLevel))
+	stop := groups.StartSummary(logger, 20*time.Millisecond, 1)
+	defer stop()
+	time.Sleep(70 * time.Millisecond)
+	lines := strings.Split(strings.TrimSpace(summary.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"new_errors":7`) || !strings.Contains(lines[0], `"message":"user <n> has no quota","count":2`) {
+		t.Fatalf("summary %q", lines)
+	}
+}
+
+func TestErrorGroupsErrorJson(t *testing.T) {
+	groups := NewErrorGroups(ErrorGroupsConfig{})
+	logger := zap.New(zapcore.NewNopCore(), zap.AddCaller(), groups.Option())
+	for _, id := range []int{17, 42} {
+		logger.Error("", getField(zap.Any("data", fmt.Sprintf(`{"order_id":%d,"err":"timeout"}`, id)))...)
+	}
+	got := groups.Groups()
+	if len(got) != 1 || got[0].Count != 2 || got[0].Message != `{"order_id":<n>,"err":"timeout"}` || !strings.Contains(got[0].Caller, "errorgroup_test.go") {
+		t.Fatalf("groups %+v", got)
+	}
+}
+
+func TestInitWithErrorGroups(t *testing.T) {
+	saved := logger2
+	defer func() { logger2 = saved }()
+
+	groups := NewErrorGroups(ErrorGroupsConfig{})
+	logger2 = nil
+	dir := t.TempDir()
+	InitWithRotation("info", filepath.Join(dir, "init.log"), RotationOptions{}, InitWithErrorGroups(groups))
+	for _, id := range []int{17, 42} {
+		Errorln(fmt.Sprintf("order %d not found", id))
+	}
+	// bufwriter 在后台写文件, 写完之前 TempDir 的清理会失败
+	waitForFile(t, filepath.Join(dir, "init.log"), "order 42 not found")
+	if got := groups.Groups(); len(got) != 1 || got[0].Count != 2 {
+		t.Fatalf("groups %+v", got)
+	}
+
+	// 不传 InitWithErrorGroups 时不归到 DefaultErrorGroups
+	before := len(DefaultErrorGroups.Groups())
+	logger2 = nil
+	InitWithRotation("info", filepath.Join(dir, "plain.log"), RotationOptions{})
+	Errorln("something else failed")
+	waitForFile(t, filepath.Join(dir, "plain.log"), "something else failed")
+	if n := len(DefaultErrorGroups.Groups()); n != before {
+		t.Fatalf("DefaultErrorGroups has %d groups, want %d", n, before)
+	}
+}
+
+func waitForFile(t *testing.T, name, substr string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, _ := os.ReadFile(name); strings.Contains(string(data), substr) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%q not found in %s", substr, name)
+}
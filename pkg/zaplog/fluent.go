@@ -0,0 +1,318 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// FluentConfig NewFluentCore 的配置, 对应 fluentd / fluent bit 的 in_forward
+type FluentConfig struct {
+	Network string // tcp / unix
+	Addr    string
+	Tag     string // 默认 "app"
+	// BatchSize > 0 时使用 PackedForward 模式, 攒够 BatchSize 条或 FlushInterval 到期时发送一次,
+	// 否则每条日志用 Message 模式单独发送
+	BatchSize     int
+	FlushInterval time.Duration // 默认 1s
+	// QueueSize 等待发送的批次数 (Message 模式为条数), 超过时丢弃新的日志,
+	// 默认 DefaultSinkQueueSize, Message 模式默认 1024
+	QueueSize int
+	// RequireAck 每次发送带 chunk id 并等待服务端 ack, 超时或失败时重发 (at-least-once)
+	RequireAck bool
+	AckTimeout time.Duration // 默认 Timeout
+	Timeout    time.Duration // 连接和写超时, 默认 3s
+	MaxRetries int           // 发送失败后重连重试次数, 默认 3, 负数不重试
+	// RetryWait 第一次重试前的等待, 之后每次翻倍, 不超过 MaxRetryWait
+	RetryWait    time.Duration // 默认 100ms
+	MaxRetryWait time.Duration // 默认 5s
+}
+
+type fluentCore struct {
+	zapcore.LevelEnabler
+	fields []zapcore.Field
+	client *fluentClient
+}
+
+// NewFluentCore 以 forward 协议 (msgpack) 发送日志, 连接和发送都在后台 goroutine 中进行.
+// 返回的 core 实现 io.Closer, Close 会发送剩余日志并停止定时 flush
+func NewFluentCore(cfg FluentConfig, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	switch cfg.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return nil, fmt.Errorf("log: unsupported fluent network %q", cfg.Network)
+	}
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("log: fluent %s address is empty", cfg.Network)
+	}
+	if cfg.Tag == "" {
+		cfg.Tag = "app"
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultSinkQueueSize
+		if cfg.BatchSize <= 0 {
+			cfg.QueueSize = 1024
+		}
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultSinkTimeout
+	}
+	if cfg.AckTimeout <= 0 {
+		cfg.AckTimeout = cfg.Timeout
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.RetryWait <= 0 {
+		cfg.RetryWait = 100 * time.Millisecond
+	}
+	if cfg.MaxRetryWait <= 0 {
+		cfg.MaxRetryWait = 5 * time.Second
+	}
+
+	client := &fluentClient{cfg: &cfg}
+	maxItems := cfg.BatchSize
+	if maxItems <= 0 {
+		maxItems = 1
+	}
+	client.batch = newBatcher(batcherConfig{
+		MaxItems:  maxItems,
+		QueueSize: cfg.QueueSize,
+		Interval:  cfg.FlushInterval,
+	}, client.send)
+	return &fluentCore{LevelEnabler: enab, client: client}, nil
+}
+
+func (c *fluentCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+	return &clone
+}
+
+func (c *fluentCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *fluentCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	all := fields
+	if len(c.fields) > 0 {
+		all = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+		all = append(all, c.fields...)
+		all = append(all, fields...)
+	}
+	return c.client.batch.add(fluentEntry{ts: ent.Time, record: appendFluentRecord(nil, ent, all)}, 0)
+}
+
+func (c *fluentCore) Sync() error {
+	return c.client.batch.flush()
+}
+
+func (c *fluentCore) Close() error {
+	err := c.client.batch.close()
+	c.client.resetConn()
+	return err
+}
+
+// fluentEntry 编码好的 record, 发送时按模式组装
+type fluentEntry struct {
+	ts     time.Time
+	record []byte
+}
+
+// fluentClient 被 With 出来的 core 共享, 连接只在 batch 的后台 goroutine 中使用
+type fluentClient struct {
+	cfg   *FluentConfig
+	batch *batcher
+	conn  net.Conn
+	rd    *bufio.Reader
+}
+
+// send Message 模式每条为 [tag, time, record, option],
+// PackedForward 模式为 [tag, entries(bin), {size, chunk}]
+func (cl *fluentClient) send(ctx context.Context, items []interface{}) error {
+	if cl.cfg.BatchSize <= 0 {
+		var err error
+		for _, item := range items {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			e := item.(fluentEntry)
+			msg := appendMsgpackArrayHeader(nil, 3+boolToInt(cl.cfg.RequireAck))
+			msg = appendMsgpackString(msg, cl.cfg.Tag)
+			msg = appendMsgpackEventTime(msg, e.ts)
+			msg = append(msg, e.record...)
+			if serr := cl.sendMsg(ctx, msg, 0); serr != nil && err == nil {
+				err = serr
+			}
+		}
+		return err
+	}
+
+	var entries []byte
+	for _, item := range items {
+		e := item.(fluentEntry)
+		entries = appendMsgpackArrayHeader(entries, 2)
+		entries = appendMsgpackEventTime(entries, e.ts)
+		entries = append(entries, e.record...)
+	}
+	msg := appendMsgpackArrayHeader(nil, 3)
+	msg = appendMsgpackString(msg, cl.cfg.Tag)
+	msg = appendMsgpackBin(msg, entries)
+	return cl.sendMsg(ctx, msg, len(items))
+}
+
+// sendMsg 补上 option 后发送, 失败时断开连接并按指数退避重连重试
+func (cl *fluentClient) sendMsg(ctx context.Context, msg []byte, size int) error {
+	var chunk string
+	if size > 0 || cl.cfg.RequireAck {
+		n := boolToInt(size > 0) + boolToInt(cl.cfg.RequireAck)
+		msg = appendMsgpackMapHeader(msg, n)
+		if size > 0 {
+			msg = appendMsgpackString(msg, "size")
+			msg = appendMsgpackInt(msg, int64(size))
+		}
+		if cl.cfg.RequireAck {
+			var id [16]byte
+			if _, err := rand.Read(id[:]); err != nil {
+				return err
+			}
+			chunk = base64.StdEncoding.EncodeToString(id[:])
+			msg = appendMsgpackString(msg, "chunk")
+			msg = appendMsgpackString(msg, chunk)
+		}
+	}
+
+	policy := retryPolicy{maxRetries: cl.cfg.MaxRetries, minBackoff: cl.cfg.RetryWait, maxBackoff: cl.cfg.MaxRetryWait}
+	if err := policy.do(ctx, func() (bool, time.Duration, error) {
+		err := cl.sendOnce(msg, chunk)
+		if err != nil {
+			cl.resetConn()
+		}
+		return err != nil, 0, err
+	}); err != nil {
+		return fmt.Errorf("log: fluent send to %s: %w", cl.cfg.Addr, err)
+	}
+	return nil
+}
+
+func (cl *fluentClient) sendOnce(msg []byte, chunk string) error {
+	if cl.conn == nil {
+		conn, err := net.DialTimeout(cl.cfg.Network, cl.cfg.Addr, cl.cfg.Timeout)
+		if err != nil {
+			return err
+		}
+		cl.conn, cl.rd = conn, bufio.NewReader(conn)
+	}
+	_ = cl.conn.SetWriteDeadline(time.Now().Add(cl.cfg.Timeout))
+	if _, err := cl.conn.Write(msg); err != nil {
+		return err
+	}
+	if chunk == "" {
+		return nil
+	}
+
+	_ = cl.conn.SetReadDeadline(time.Now().Add(cl.cfg.AckTimeout))
+	resp, err := decodeMsgpack(cl.rd)
+	if err != nil {
+		return fmt.Errorf("read ack: %w", err)
+	}
+	if m, ok := resp.(map[string]interface{}); !ok || m["ack"] != chunk {
+		return fmt.Errorf("unexpected ack %v for chunk %s", resp, chunk)
+	}
+	return nil
+}
+
+func (cl *fluentClient) resetConn() {
+	if cl.conn != nil {
+		_ = cl.conn.Close()
+		cl.conn, cl.rd = nil, nil
+	}
+}
+
+// appendFluentRecord record 为 map: level, msg, logger, caller, stacktrace 和 zap fields.
+// 一个 field 可能写入其他 key 或多个 key (zap.Inline, zap.Error 的 errorVerbose),
+// 所以按 enc.Fields 计数和输出: 先按 field 顺序, 其余 key 排序后追加
+func appendFluentRecord(b []byte, ent zapcore.Entry, fields []zapcore.Field) []byte {
+	enc := zapcore.NewMapObjectEncoder()
+	for i := range fields {
+		fields[i].AddTo(enc)
+	}
+	// 与固定字段重名的 field 被忽略
+	for _, k := range []string{"level", "msg", "logger", "caller", "stacktrace"} {
+		delete(enc.Fields, k)
+	}
+	keys := make([]string, 0, len(enc.Fields))
+	seen := make(map[string]struct{}, len(enc.Fields))
+	for _, f := range fields {
+		if _, ok := enc.Fields[f.Key]; ok {
+			if _, dup := seen[f.Key]; !dup {
+				seen[f.Key] = struct{}{}
+				keys = append(keys, f.Key)
+			}
+		}
+	}
+	rest := make([]string, 0, len(enc.Fields)-len(keys))
+	for k := range enc.Fields {
+		if _, ok := seen[k]; !ok {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	keys = append(keys, rest...)
+
+	n := 2 + len(keys)
+	if ent.LoggerName != "" {
+		n++
+	}
+	if ent.Caller.Defined {
+		n++
+	}
+	if ent.Stack != "" {
+		n++
+	}
+
+	b = appendMsgpackMapHeader(b, n)
+	b = appendMsgpackString(b, "level")
+	b = appendMsgpackString(b, ent.Level.String())
+	b = appendMsgpackString(b, "msg")
+	b = appendMsgpackString(b, ent.Message)
+	if ent.LoggerName != "" {
+		b = appendMsgpackString(b, "logger")
+		b = appendMsgpackString(b, ent.LoggerName)
+	}
+	if ent.Caller.Defined {
+		b = appendMsgpackString(b, "caller")
+		b = appendMsgpackString(b, ent.Caller.TrimmedPath())
+	}
+	if ent.Stack != "" {
+		b = appendMsgpackString(b, "stacktrace")
+		b = appendMsgpackString(b, ent.Stack)
+	}
+	for _, k := range keys {
+		b = appendMsgpackString(b, k)
+		b = appendMsgpack(b, enc.Fields[k])
+	}
+	return b
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,199 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// fluentServer in_forward 的替身, 把收到的消息发到 msgs, dropFirst 个连接不回 ack 直接断开
+type fluentServer struct {
+	ln        net.Listener
+	msgs      chan []interface{}
+	dropFirst int
+}
+
+func newFluentServer(t *testing.T, network, addr string, dropFirst int) *fluentServer {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fluentServer{ln: ln, msgs: make(chan []interface{}, 16), dropFirst: dropFirst}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fluentServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		drop := s.dropFirst > 0
+		s.dropFirst--
+		go func() {
+			defer conn.Close()
+			rd := bufio.NewReader(conn)
+			for {
+				v, err := decodeMsgpack(rd)
+				if err != nil {
+					return
+				}
+				if drop {
+					return
+				}
+				msg := v.([]interface{})
+				s.msgs <- msg
+				if opt, ok := msg[len(msg)-1].(map[string]interface{}); ok && opt["chunk"] != nil {
+					conn.Write(appendMsgpack(nil, map[string]interface{}{"ack": opt["chunk"]}))
+				}
+			}
+		}()
+	}
+}
+
+func (s *fluentServer) next(t *testing.T) []interface{} {
+	t.Helper()
+	select {
+	case msg := <-s.msgs:
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("no fluent message received")
+	}
+	return nil
+}
+
+func TestFluentMessageMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fluent.sock")
+	server := newFluentServer(t, "unix", path, 0)
+
+	core, err := NewFluentCore(FluentConfig{Network: "unix", Addr: path, Tag: "app.test"}, zapcore.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zap.New(core).With(zap.String("svc", "a")).Info("hello", zap.Int("n", -5), zap.Any("obj", map[string]int{"k": 1}))
+
+	msg := server.next(t)
+	if len(msg) != 3 || msg[0] != "app.test" {
+		t.Fatalf("message %v", msg)
+	}
+	if ts, ok := msg[1].(msgpackExt); !ok || ts.Type != 0 || len(ts.Data) != 8 {
+		t.Fatalf("event time %#v", msg[1])
+	}
+	record := msg[2].(map[string]interface{})
+	if record["msg"] != "hello" || record["level"] != "info" || record["svc"] != "a" || record["n"] != int64(-5) {
+		t.Fatalf("record %v", record)
+	}
+	if obj, ok := record["obj"].(map[string]interface{}); !ok || obj["k"] != float64(1) {
+		t.Fatalf("obj %#v", record["obj"])
+	}
+}
+
+func TestFluentPackedForwardAck(t *testing.T) {
+	// 第一个连接不回 ack, 客户端需要重连并重发
+	server := newFluentServer(t, "tcp", "127.0.0.1:0", 1)
+
+	core, err := NewFluentCore(FluentConfig{
+		Network:       "tcp",
+		Addr:          server.ln.Addr().String(),
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+		RequireAck:    true,
+		AckTimeout:    200 * time.Millisecond,
+		RetryWait:     10 * time.Millisecond,
+	}, zapcore.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.(io.Closer).Close()
+	logger := zap.New(core)
+	for _, m := range []string{"a", "b", "c", "d"} {
+		logger.Info(m)
+	}
+
+	msg := server.next(t)
+	opt := msg[2].(map[string]interface{})
+	if msg[0] != "app" || opt["size"] != int64(3) || opt["chunk"] == nil {
+		t.Fatalf("packed forward %v", msg)
+	}
+	rd := bufio.NewReader(bytes.NewReader(msg[1].([]byte)))
+	var got []string
+	for {
+		entry, err := decodeMsgpack(rd)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, entry.([]interface{})[1].(map[string]interface{})["msg"].(string))
+	}
+	if len(got) != 3 || got[0] != "a" || got[2] != "c" {
+		t.Fatalf("entries %v", got)
+	}
+
+	// 剩余的一条由 Sync 发送
+	if err := logger.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if opt := server.next(t)[2].(map[string]interface{}); opt["size"] != int64(1) {
+		t.Fatalf("option %v", opt)
+	}
+}
+
+// verboseError %+v 输出额外信息, zap.Error 会多写一个 errorVerbose
+type verboseError struct{}
+
+func (verboseError) Error() string { return "boom" }
+
+func (verboseError) Format(s fmt.State, verb rune) {
+	if s.Flag('+') {
+		io.WriteString(s, "boom\n\tat main.go:1")
+		return
+	}
+	io.WriteString(s, "boom")
+}
+
+func TestFluentRecordFieldKeys(t *testing.T) {
+	inline := zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("user", "u1")
+		enc.AddInt("age", 3)
+		return nil
+	})
+	fields := []zapcore.Field{
+		zap.String("first", "x"),
+		zap.Inline(inline),
+		zap.Error(verboseError{}),
+		zap.String("msg", "ignored"),
+	}
+	b := appendFluentRecord(nil, zapcore.Entry{Level: zapcore.ErrorLevel, Message: "m"}, fields)
+	rd := bufio.NewReader(bytes.NewReader(b))
+	v, err := decodeMsgpack(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rd.ReadByte(); err != io.EOF {
+		t.Fatalf("trailing data after record, map header count is wrong")
+	}
+	record := v.(map[string]interface{})
+	want := map[string]interface{}{
+		"level": "error", "msg": "m", "first": "x", "user": "u1", "age": int64(3),
+		"error": "boom", "errorVerbose": "boom\n\tat main.go:1",
+	}
+	if len(record) != len(want) {
+		t.Fatalf("record %v", record)
+	}
+	for k, w := range want {
+		if record[k] != w {
+			t.Fatalf("%s = %#v, want %#v (record %v)", k, record[k], w, record)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	stdjson "encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// GelfCompression UDP 报文的压缩方式, TCP 不压缩
+type GelfCompression int
+
+const (
+	GelfCompressGzip GelfCompression = iota
+	GelfCompressZlib
+	GelfCompressNone
+)
+
+const (
+	// DefaultGelfChunkSize UDP 分片大小, 适合常见 MTU
+	DefaultGelfChunkSize = 1420
+
+	gelfChunkHeaderSize = 12
+	gelfMaxChunks       = 128
+)
+
+// GelfConfig NewGelfCore 的配置
+type GelfConfig struct {
+	Network     string // udp / tcp, tcp 以 \0 分帧
+	Addr        string
+	Host        string // 默认 os.Hostname()
+	Compression GelfCompression
+	ChunkSize   int // UDP 分片大小, 默认 DefaultGelfChunkSize
+	Timeout     time.Duration
+}
+
+type gelfCore struct {
+	zapcore.LevelEnabler
+	cfg    *GelfConfig
+	fields []zapcore.Field
+	out    *netSink
+}
+
+// NewGelfCore 按 GELF 1.1 发送到 Graylog: zap field 作为 "_" 开头的附加字段,
+// stacktrace 放在 full_message, level 为 syslog severity
+func NewGelfCore(cfg GelfConfig, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	switch cfg.Network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("log: unsupported gelf network %q", cfg.Network)
+	}
+	if cfg.Host == "" {
+		cfg.Host, _ = os.Hostname()
+	}
+	if cfg.ChunkSize <= gelfChunkHeaderSize {
+		cfg.ChunkSize = DefaultGelfChunkSize
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultSinkTimeout
+	}
+	return &gelfCore{
+		LevelEnabler: enab,
+		cfg:          &cfg,
+		out:          &netSink{network: cfg.Network, addr: cfg.Addr, timeout: cfg.Timeout},
+	}, nil
+}
+
+func (c *gelfCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+	return &clone
+}
+
+func (c *gelfCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *gelfCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	all := fields
+	if len(c.fields) > 0 {
+		all = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+		all = append(all, c.fields...)
+		all = append(all, fields...)
+	}
+	msg, err := EncodeGelf(c.cfg.Host, ent, all)
+	if err != nil {
+		return err
+	}
+	if c.out.network[:3] == "tcp" {
+		_, err = c.out.Write(append(msg, 0))
+		return err
+	}
+	if msg, err = c.compress(msg); err != nil {
+		return err
+	}
+	return c.writeChunked(msg)
+}
+
+func (c *gelfCore) Sync() error {
+	return c.out.Sync()
+}
+
+func (c *gelfCore) compress(msg []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch c.cfg.Compression {
+	case GelfCompressGzip:
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(msg); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case GelfCompressZlib:
+		w := zlib.NewWriter(&buf)
+		if _, err := w.Write(msg); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return msg, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// writeChunked 超过 ChunkSize 时按 GELF chunk 格式分片:
+// 0x1e 0x0f + 8 字节 message id + 序号 + 总数 + 数据
+func (c *gelfCore) writeChunked(msg []byte) error {
+	if len(msg) <= c.cfg.ChunkSize {
+		_, err := c.out.Write(msg)
+		return err
+	}
+	dataSize := c.cfg.ChunkSize - gelfChunkHeaderSize
+	count := (len(msg) + dataSize - 1) / dataSize
+	if count > gelfMaxChunks {
+		return fmt.Errorf("log: gelf message of %d bytes needs %d chunks, max %d", len(msg), count, gelfMaxChunks)
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	chunk := make([]byte, 0, c.cfg.ChunkSize)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * dataSize
+		if end > len(msg) {
+			end = len(msg)
+		}
+		chunk = append(chunk[:0], 0x1e, 0x0f)
+		chunk = append(chunk, id[:]...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, msg[i*dataSize:end]...)
+		if _, err := c.out.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeGelf 生成 GELF 1.1 JSON, 附加字段只能是字符串或数字, 其他类型转成 JSON 字符串
+func EncodeGelf(host string, ent zapcore.Entry, fields []zapcore.Field) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, 512))
+	add := func(key string, value interface{}) error {
+		data, err := stdjson.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(strconv.Quote(key))
+		buf.WriteByte(':')
+		buf.Write(data)
+		return nil
+	}
+
+	buf.WriteByte('{')
+	_ = add("version", "1.1")
+	_ = add("host", host)
+	_ = add("short_message", ent.Message)
+	if ent.Stack != "" {
+		_ = add("full_message", ent.Stack)
+	}
+	buf.WriteString(`,"timestamp":`)
+	buf.WriteString(strconv.FormatFloat(float64(ent.Time.UnixNano())/1e9, 'f', 6, 64))
+	buf.WriteString(`,"level":`)
+	buf.WriteString(strconv.Itoa(SyslogSeverity(ent.Level)))
+	// 附加字段名不能重复, 用户字段与 _logger/_caller 或其他字段转换后重名时在后面加 "_"
+	used := make(map[string]struct{})
+	if ent.LoggerName != "" {
+		_ = add("_logger", ent.LoggerName)
+		used["_logger"] = struct{}{}
+	}
+	if ent.Caller.Defined {
+		_ = add("_caller", ent.Caller.TrimmedPath())
+		used["_caller"] = struct{}{}
+	}
+
+	// zap.Inline 等 field 写入的 key 与 Field.Key 不同, 以 enc.Fields 为准, 按 key 排序保证输出稳定
+	enc := zapcore.NewMapObjectEncoder()
+	for i := range fields {
+		fields[i].AddTo(enc)
+	}
+	keys := make([]string, 0, len(enc.Fields))
+	for k := range enc.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := gelfFieldName(k)
+		for {
+			if _, dup := used[name]; !dup {
+				break
+			}
+			name += "_"
+		}
+		used[name] = struct{}{}
+		if err := add(name, gelfValue(enc.Fields[k])); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// gelfFieldName 附加字段名 ^_[\w.\-]*$, "_id" 为保留字段
+func gelfFieldName(key string) string {
+	b := make([]byte, 0, len(key)+1)
+	b = append(b, '_')
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-' {
+			b = append(b, c)
+		} else {
+			b = append(b, '_')
+		}
+	}
+	if string(b) == "_id" {
+		return "_id_"
+	}
+	return string(b)
+}
+
+func gelfValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string, int64, uint64, float64, int, float32:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case time.Duration:
+		return val.Seconds()
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return val.String()
+	}
+	data, err := stdjson.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
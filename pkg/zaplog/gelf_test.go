@@ -0,0 +1,146 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	stdjson "encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestGelfUDPChunked(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	core, err := NewGelfCore(GelfConfig{Network: "udp", Addr: pc.LocalAddr().String(), Host: "h1", ChunkSize: 200}, zapcore.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewZapLoggerWithOptions(nil, WithExtraCores(core), WithFallbackWriters(&syncBuffer{}))
+	// 随机内容压缩后仍需要多个分片
+	payload := make([]byte, 0, 4096)
+	for i := 0; len(payload) < 4096; i++ {
+		payload = append(payload, byte('a'+i*7%26), byte('0'+i*13%10))
+	}
+	l.Error("big", zap.String("payload", string(payload)), zap.Int("id", 7), zap.Any("tags", []string{"a"}))
+
+	chunks := map[byte][]byte{}
+	var count byte
+	buf := make([]byte, 2048)
+	for count == 0 || len(chunks) < int(count) {
+		_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("read chunk: %v (got %d)", err, len(chunks))
+		}
+		if n > 200 || buf[0] != 0x1e || buf[1] != 0x0f {
+			t.Fatalf("bad chunk header % x, size %d", buf[:12], n)
+		}
+		count = buf[11]
+		chunks[buf[10]] = append([]byte(nil), buf[12:n]...)
+	}
+	if count < 2 {
+		t.Fatalf("expected several chunks, got %d", count)
+	}
+	var joined []byte
+	for i := byte(0); i < count; i++ {
+		joined = append(joined, chunks[i]...)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(joined))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg map[string]interface{}
+	if err := stdjson.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["version"] != "1.1" || msg["host"] != "h1" || msg["short_message"] != "big" || msg["level"] != float64(3) {
+		t.Fatalf("header fields %v", msg)
+	}
+	if msg["_payload"] != string(payload) || msg["_id_"] != float64(7) || msg["_tags"] != `["a"]` {
+		t.Fatalf("additional fields %v", msg)
+	}
+}
+
+func TestGelfTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	frames := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		frame, _ := bufio.NewReader(conn).ReadString(0)
+		frames <- frame
+	}()
+
+	core, err := NewGelfCore(GelfConfig{Network: "tcp", Addr: ln.Addr().String()}, zapcore.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := NewJSONLogger(WithDisableConsole(), WithCores(core))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Named("svc").Warn("tcp")
+	select {
+	case frame := <-frames:
+		if !strings.HasSuffix(frame, "}\x00") || !strings.Contains(frame, `"short_message":"tcp"`) ||
+			!strings.Contains(frame, `"_logger":"svc"`) || !strings.Contains(frame, `"level":4`) {
+			t.Fatalf("frame %q", frame)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no frame received")
+	}
+}
+
+func TestEncodeGelfFieldCollisions(t *testing.T) {
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), LoggerName: "svc", Message: "m",
+		Caller: zapcore.NewEntryCaller(0, "/src/app/main.go", 10, true)}
+	data, err := EncodeGelf("h1", ent, []zapcore.Field{
+		zap.String("logger", "user logger"),
+		zap.String("caller", "user caller"),
+		zap.String("a b", "space"),
+		zap.String("a_b", "underscore"),
+		zap.Inline(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("inlined", "yes")
+			return nil
+		})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 重复的 key 在 Unmarshal 时会被覆盖, 先确认每个 key 只出现一次
+	if n := strings.Count(string(data), `"_logger"`); n != 1 {
+		t.Fatalf("_logger appears %d times: %s", n, data)
+	}
+	var msg map[string]interface{}
+	if err := stdjson.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["_logger"] != "svc" || msg["_logger_"] != "user logger" || msg["_caller"] != "app/main.go:10" || msg["_caller_"] != "user caller" {
+		t.Fatalf("logger/caller %s", data)
+	}
+	if msg["_a_b"] == nil || msg["_a_b_"] == nil || msg["_a_b"] == msg["_a_b_"] || msg["_inlined"] != "yes" {
+		t.Fatalf("fields %s", data)
+	}
+}
@@ -0,0 +1,214 @@
+package log
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// GinLogFormat 访问日志格式
+type GinLogFormat int
+
+const (
+	GinLogJSON     GinLogFormat = iota // 每个字段一个 zap field, msg 为 GinLoggerConfig.Message
+	GinLogCommon                       // Apache Common Log Format, 整行作为 msg, 另带 request_id field
+	GinLogCombined                     // Apache Combined Log Format, 比 Common 多 Referer 和 User-Agent
+)
+
+// DefaultRequestIDHeader 读取和回写 request id 的请求头
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen 客户端传入的 request id 最长字节数, 超过或含其他字符时重新生成
+const maxRequestIDLen = 128
+
+// GinLoggerConfig GinLogger 的配置
+type GinLoggerConfig struct {
+	Format  GinLogFormat
+	Message string // GinLogJSON 的 msg, 默认 "access"
+	// SkipPaths 不记录的路径, 精确匹配 URL path, 如 /healthz
+	SkipPaths []string
+	Skip      func(c *gin.Context) bool
+	// 按状态码和耗时选等级: 达到 ErrorStatus 为 Error, 达到 WarnStatus 或耗时达到 SlowThreshold 为 Warn, 其余 Info
+	ErrorStatus   int           // 默认 500
+	WarnStatus    int           // 默认 400
+	SlowThreshold time.Duration // 为 0 时不按耗时提升等级
+	// RequestIDHeader 请求没有带时生成一个, 并写到响应头和 gin.Context 的 "request_id".
+	// 请求带的 id 超过 128 字节或含字母, 数字和 "-_.:" 以外的字符时也重新生成
+	RequestIDHeader string // 默认 X-Request-Id
+}
+
+// GinLogger 记录每个请求的 gin 中间件, logger 可以是 NewJSONLogger, NewLogger, GetLogger()
+// 返回的 *zap.Logger, ZapLogOper 用 Sugar().Desugar().
+// request id 还会通过 SetContext 放进 c.Request 的 context, handler 中 WithContext(c.Request.Context()) 会带上
+func GinLogger(logger *zap.Logger, cfg GinLoggerConfig) gin.HandlerFunc {
+	if cfg.Message == "" {
+		cfg.Message = "access"
+	}
+	if cfg.ErrorStatus <= 0 {
+		cfg.ErrorStatus = 500
+	}
+	if cfg.WarnStatus <= 0 {
+		cfg.WarnStatus = 400
+	}
+	if cfg.RequestIDHeader == "" {
+		cfg.RequestIDHeader = DefaultRequestIDHeader
+	}
+	skip := make(map[string]struct{}, len(cfg.SkipPaths))
+	for _, p := range cfg.SkipPaths {
+		skip[p] = struct{}{}
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(cfg.RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			c.Request.Header.Set(cfg.RequestIDHeader, id)
+		}
+		c.Header(cfg.RequestIDHeader, id)
+		c.Set("request_id", id)
+		c.Request = c.Request.WithContext(SetContext(c.Request.Context(), zap.String("request_id", id)))
+		// 在 handler 之前取, handler 可能改写 c.Request.URL
+		path, rawQuery := c.Request.URL.Path, c.Request.URL.RawQuery
+
+		c.Next()
+
+		if _, ok := skip[path]; ok {
+			return
+		}
+		if cfg.Skip != nil && cfg.Skip(c) {
+			return
+		}
+		latency := time.Since(start)
+		status := c.Writer.Status()
+		lvl := zapcore.InfoLevel
+		switch {
+		case status >= cfg.ErrorStatus:
+			lvl = zapcore.ErrorLevel
+		case status >= cfg.WarnStatus, cfg.SlowThreshold > 0 && latency >= cfg.SlowThreshold:
+			lvl = zapcore.WarnLevel
+		}
+
+		if cfg.Format != GinLogJSON {
+			if ce := logger.Check(lvl, apacheLogLine(c, cfg.Format, start)); ce != nil {
+				ce.Write(zap.String("request_id", id))
+			}
+			return
+		}
+		ce := logger.Check(lvl, cfg.Message)
+		if ce == nil {
+			return
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = path // 没有匹配到路由, 如 404
+		}
+		size := c.Writer.Size()
+		if size < 0 {
+			size = 0
+		}
+		fields := []zap.Field{
+			zap.String("method", c.Request.Method),
+			zap.String("route", route),
+			zap.String("path", path),
+			zap.Int("status", status),
+			zap.Duration("latency", latency),
+			zap.Int("bytes", size),
+			zap.String("client_ip", c.ClientIP()),
+			zap.String("user_agent", c.Request.UserAgent()),
+			zap.String("request_id", id),
+		}
+		if rawQuery != "" {
+			fields = append(fields, zap.String("query", rawQuery))
+		}
+		if errs := c.Errors.ByType(gin.ErrorTypePrivate); len(errs) > 0 {
+			fields = append(fields, zap.Strings("errors", errs.Errors()))
+		}
+		ce.Write(fields...)
+	}
+}
+
+// apacheLogLine %h %l %u %t "%r" %>s %b, Combined 再加 "%{Referer}i" "%{User-agent}i"
+func apacheLogLine(c *gin.Context, format GinLogFormat, start time.Time) string {
+	user := "-"
+	if u, _, ok := c.Request.BasicAuth(); ok && u != "" {
+		user = u
+	}
+	size := "-"
+	if n := c.Writer.Size(); n > 0 {
+		size = strconv.Itoa(n)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - %s [%s] \"%s %s %s\" %d %s",
+		c.ClientIP(), apacheEscape(user), start.Format("02/Jan/2006:15:04:05 -0700"),
+		apacheEscape(c.Request.Method), apacheEscape(c.Request.RequestURI), apacheEscape(c.Request.Proto), c.Writer.Status(), size)
+	if format == GinLogCombined {
+		fmt.Fprintf(&b, " %s %s", apacheQuote(c.Request.Referer()), apacheQuote(c.Request.UserAgent()))
+	}
+	return b.String()
+}
+
+// apacheQuote 空值为 "-", 其余按 apacheEscape 转义后加引号
+func apacheQuote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return `"` + apacheEscape(s) + `"`
+}
+
+// apacheEscape 同 Apache mod_log_config: 引号和反斜杠前加 \, 控制字符和非 ASCII 字节转为 \xhh,
+// 请求行和用户名中的引号, 换行不能伪造出另一条日志
+func apacheEscape(s string) string {
+	const hexDigits = "0123456789abcdef"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20 || c >= 0x7f:
+			b.WriteString(`\x`)
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0xf])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// validRequestID 客户端传入的 id 会写进响应头和日志, 只接受有限长度的常见字符
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,145 @@
+package log
+
+import (
+	stdjson "encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func newGinTestEngine(logger *zap.Logger, cfg GinLoggerConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GinLogger(logger, cfg))
+	r.GET("/users/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "user "+c.Param("id"))
+	})
+	r.GET("/slow", func(c *gin.Context) {
+		time.Sleep(20 * time.Millisecond)
+		c.Status(http.StatusNoContent)
+	})
+	r.GET("/boom", func(c *gin.Context) {
+		c.Error(http.ErrBodyNotAllowed)
+		c.Status(http.StatusInternalServerError)
+	})
+	r.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func TestGinLoggerJSON(t *testing.T) {
+	out := &syncBuffer{}
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), out, zapcore.DebugLevel))
+	r := newGinTestEngine(logger, GinLoggerConfig{SkipPaths: []string{"/healthz"}, SlowThreshold: 10 * time.Millisecond})
+
+	for _, target := range []string{"/users/42?verbose=1", "/slow", "/boom", "/missing", "/healthz"} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("User-Agent", "curl/8.0")
+		if target == "/users/42?verbose=1" {
+			req.Header.Set(DefaultRequestIDHeader, "req-1")
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Header().Get(DefaultRequestIDHeader) == "" {
+			t.Fatalf("%s: no request id in response", target)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("lines %q", lines)
+	}
+	var entries []map[string]interface{}
+	for _, line := range lines {
+		var m map[string]interface{}
+		if err := stdjson.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, m)
+	}
+	first := entries[0]
+	if first["level"] != "info" || first["msg"] != "access" || first["method"] != "GET" || first["route"] != "/users/:id" ||
+		first["path"] != "/users/42" || first["query"] != "verbose=1" || first["status"] != float64(200) ||
+		first["bytes"] != float64(len("user 42")) || first["client_ip"] != "192.0.2.1" ||
+		first["user_agent"] != "curl/8.0" || first["request_id"] != "req-1" {
+		t.Fatalf("entry %v", first)
+	}
+	if _, ok := first["latency"].(float64); !ok {
+		t.Fatalf("latency %v", first["latency"])
+	}
+	for i, want := range []struct{ level, route string }{{"warn", "/slow"}, {"error", "/boom"}, {"warn", "/missing"}} {
+		e := entries[i+1]
+		if e["level"] != want.level || e["route"] != want.route {
+			t.Fatalf("entry %d %v, want %+v", i+1, e, want)
+		}
+	}
+	if errs, _ := entries[2]["errors"].([]interface{}); len(errs) != 1 {
+		t.Fatalf("errors %v", entries[2]["errors"])
+	}
+}
+
+func TestGinLoggerApache(t *testing.T) {
+	encCfg := zapcore.EncoderConfig{MessageKey: "msg", LineEnding: zapcore.DefaultLineEnding}
+	for _, tt := range []struct {
+		format GinLogFormat
+		re     string
+	}{
+		{GinLogCommon, `^192\.0\.2\.1 - frank \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] "GET /users/7\?a=b HTTP/1\.1" 200 6\t\{"request_id": "[0-9a-f]{16}"\}$`},
+		{GinLogCombined, `^192\.0\.2\.1 - frank \[.+\] "GET /users/7\?a=b HTTP/1\.1" 200 6 "http://example\.com/" "Mozilla \\"x\\""\t`},
+	} {
+		out := &syncBuffer{}
+		logger := zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(encCfg), out, zapcore.InfoLevel))
+		r := newGinTestEngine(logger, GinLoggerConfig{Format: tt.format})
+		req := httptest.NewRequest("GET", "/users/7?a=b", nil)
+		req.SetBasicAuth("frank", "secret")
+		req.Header.Set("Referer", "http://example.com/")
+		req.Header.Set("User-Agent", `Mozilla "x"`)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		if line := strings.TrimSpace(out.String()); !regexp.MustCompile(tt.re).MatchString(line) {
+			t.Fatalf("format %d line %q", tt.format, line)
+		}
+	}
+}
+
+func TestGinLoggerUntrustedInput(t *testing.T) {
+	encCfg := zapcore.EncoderConfig{MessageKey: "msg", LineEnding: zapcore.DefaultLineEnding}
+	out := &syncBuffer{}
+	logger := zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(encCfg), out, zapcore.InfoLevel))
+	r := newGinTestEngine(logger, GinLoggerConfig{Format: GinLogCommon})
+
+	// 请求行和用户名中的引号, 换行被转义, 不能伪造字段或另一行日志
+	req := httptest.NewRequest("GET", `/users/7?q="x"`, nil)
+	req.SetBasicAuth("eve\" 200 1\n1.2.3.4 - admin", "secret")
+	req.Header.Set(DefaultRequestIDHeader, "bad id\"")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	line := strings.TrimSpace(out.String())
+	if strings.Contains(line, "\n") || !strings.Contains(line, ` - eve\" 200 1\n1.2.3.4 - admin [`) ||
+		!strings.Contains(line, `"GET /users/7?q=\"x\" HTTP/1.1"`) {
+		t.Fatalf("line %q", line)
+	}
+	if id := rec.Header().Get(DefaultRequestIDHeader); !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(id) {
+		t.Fatalf("request id %q not replaced", id)
+	}
+
+	for id, keep := range map[string]bool{
+		"req-1":                            true,
+		"4bf92f3577b34da6a3ce929d0e0e4736": true,
+		strings.Repeat("a", 129):           false,
+		"id\r\nX-Admin: 1":                 false,
+	} {
+		req := httptest.NewRequest("GET", "/healthz", nil)
+		req.Header[DefaultRequestIDHeader] = []string{id}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if got := rec.Header().Get(DefaultRequestIDHeader); (got == id) != keep {
+			t.Fatalf("request id %q -> %q", id, got)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// HTTPBodyFormat 一个批次的请求体格式
+type HTTPBodyFormat int
+
+const (
+	HTTPBodyNDJSON    HTTPBodyFormat = iota // 每行一条, application/x-ndjson
+	HTTPBodyJSONArray                       // [entry,entry], 要求 encoder 输出 JSON
+	HTTPBodyTemplate                        // HTTPSinkConfig.Template, 数据为 HTTPBatch
+)
+
+// HTTPBatch HTTPBodyTemplate 模板的数据
+type HTTPBatch struct {
+	Lines []string // 编码后的日志, 不含换行
+	Count int
+	Time  time.Time
+}
+
+// HTTPSinkConfig NewHTTPSink 的配置
+type HTTPSinkConfig struct {
+	URL    string
+	Method string // 默认 POST
+	Format HTTPBodyFormat
+	// Template text/template 模板, 可用 join 和 json 函数, 如
+	//	{"source":"app","logs":[{{join .Lines ","}}]}
+	Template      string
+	ContentType   string // 默认按 Format, 模板为 application/json
+	Gzip          bool
+	Headers       map[string]string
+	Username      string // basic auth
+	Password      string
+	BearerToken   string        // Authorization: Bearer <BearerToken>
+	MaxBatchBytes int           // 缓冲达到多少字节发送, 默认 1MB
+	FlushInterval time.Duration // 最长等待多久发送, 默认 1s
+	QueueSize     int           // 等待发送的满批次数, 超过时丢弃新的批次, 默认 DefaultSinkQueueSize
+	// 网络错误, 429 和 5xx 重试, 优先使用 Retry-After (不超过 MaxBackoff), 否则指数退避
+	MaxRetries int           // 默认 3, 负数不重试
+	MinBackoff time.Duration // 默认 500ms
+	MaxBackoff time.Duration // 默认 30s
+	Timeout    time.Duration // 单次请求超时, 默认 10s
+	Client     *http.Client
+}
+
+// HTTPSink 缓冲编码后的日志并批量 POST, 实现 zapcore.WriteSyncer 和 io.Closer,
+// 可以作为 NewZapLogger 的 writer, 用 WithOwnedClosers 交给 logger 后, logger 的 Close 会先写完异步队列再关闭它.
+// Write 只写内存, 发送和重试都在后台 goroutine 中进行
+type HTTPSink struct {
+	cfg   *HTTPSinkConfig
+	tmpl  *template.Template
+	batch *batcher
+}
+
+// NewHTTPSink 创建 HTTPSink 并启动定时发送
+func NewHTTPSink(cfg HTTPSinkConfig) (*HTTPSink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("log: http sink url is empty")
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+	if cfg.MaxBatchBytes <= 0 {
+		cfg.MaxBatchBytes = 1 << 20
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultSinkQueueSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+
+	s := &HTTPSink{cfg: &cfg}
+	switch cfg.Format {
+	case HTTPBodyNDJSON:
+		if cfg.ContentType == "" {
+			cfg.ContentType = "application/x-ndjson"
+		}
+	case HTTPBodyJSONArray:
+		if cfg.ContentType == "" {
+			cfg.ContentType = "application/json"
+		}
+	case HTTPBodyTemplate:
+		tmpl, err := template.New("http_sink").Funcs(template.FuncMap{
+			"join": strings.Join,
+			"json": func(v interface{}) (string, error) {
+				data, err := stdjson.Marshal(v)
+				return string(data), err
+			},
+		}).Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("log: http sink template: %w", err)
+		}
+		s.tmpl = tmpl
+		if cfg.ContentType == "" {
+			cfg.ContentType = "application/json"
+		}
+	default:
+		return nil, fmt.Errorf("log: unsupported http body format %d", cfg.Format)
+	}
+	s.batch = newBatcher(batcherConfig{
+		MaxBytes:  cfg.MaxBatchBytes,
+		QueueSize: cfg.QueueSize,
+		Interval:  cfg.FlushInterval,
+	}, s.send)
+	return s, nil
+}
+
+// Write 每次调用为一条日志 (zap 的 encoder 输出), 末尾的换行会被去掉
+func (s *HTTPSink) Write(p []byte) (int, error) {
+	line := string(bytes.TrimRight(p, "\r\n"))
+	if err := s.batch.add(line, len(line)+1); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Sync 等待缓冲和排队的日志发送完 (最多 DefaultSinkFlushTimeout), 返回此前后台发送的错误和丢弃的条数
+func (s *HTTPSink) Sync() error {
+	return s.batch.flush()
+}
+
+// Close 发送剩余日志并停止定时发送, 之后的 Write 返回 ErrSinkClosed
+func (s *HTTPSink) Close() error {
+	return s.batch.close()
+}
+
+// Dropped 发送队列满时丢弃的日志条数
+func (s *HTTPSink) Dropped() uint64 {
+	return s.batch.Dropped()
+}
+
+func (s *HTTPSink) send(ctx context.Context, items []interface{}) error {
+	lines := make([]string, len(items))
+	for i, item := range items {
+		lines[i] = item.(string)
+	}
+	body, err := s.encode(lines)
+	if err != nil {
+		return err
+	}
+	policy := retryPolicy{maxRetries: s.cfg.MaxRetries, minBackoff: s.cfg.MinBackoff, maxBackoff: s.cfg.MaxBackoff}
+	return policy.do(ctx, func() (bool, time.Duration, error) {
+		return s.post(ctx, body)
+	})
+}
+
+func (s *HTTPSink) encode(lines []string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if s.cfg.Gzip {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+	var err error
+	switch s.cfg.Format {
+	case HTTPBodyNDJSON:
+		_, err = io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	case HTTPBodyJSONArray:
+		_, err = io.WriteString(w, "["+strings.Join(lines, ",")+"]")
+	case HTTPBodyTemplate:
+		err = s.tmpl.Execute(w, HTTPBatch{Lines: lines, Count: len(lines), Time: time.Now()})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("log: http sink encode: %w", err)
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// post 返回是否可以重试, 以及服务端要求的等待时间
+func (s *HTTPSink) post(ctx context.Context, body []byte) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, s.cfg.Method, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", s.cfg.ContentType)
+	if s.cfg.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+	if s.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.BearerToken)
+	}
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return true, 0, fmt.Errorf("log: http sink: %w", err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return false, 0, nil
+	}
+	err = fmt.Errorf("log: http sink: status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return false, 0, err
+	}
+	return true, retryAfter(resp.Header.Get("Retry-After")), err
+}
@@ -0,0 +1,186 @@
+package log
+
+import (
+	"compress/gzip"
+	stdjson "encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type httpSinkServer struct {
+	mu         sync.Mutex
+	throttle   int    // 前几次请求返回 429
+	retryAfter string // 429 的 Retry-After, 默认 0
+	bodies     []string
+	headers    []http.Header
+}
+
+func (s *httpSinkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.throttle > 0 {
+		s.throttle--
+		if s.retryAfter == "" {
+			s.retryAfter = "0"
+		}
+		w.Header().Set("Retry-After", s.retryAfter)
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	data, _ := io.ReadAll(body)
+	s.bodies = append(s.bodies, string(data))
+	s.headers = append(s.headers, r.Header)
+}
+
+func TestHTTPSinkAsyncClose(t *testing.T) {
+	server := &httpSinkServer{throttle: 1}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	sink, err := NewHTTPSink(HTTPSinkConfig{
+		URL:           srv.URL,
+		Gzip:          true,
+		BearerToken:   "t0ken",
+		FlushInterval: time.Hour,
+		MinBackoff:    time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewZapLoggerWithOptions(nil, WithWriteMode(WriteModeAsync), WithFallbackWriters(sink), WithOwnedClosers(sink))
+	for i := 0; i < 3; i++ {
+		l.Info("entry", zap.Int("i", i))
+	}
+	// Close 先写完异步队列, 再关闭 sink 发送剩余日志
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sink.Write([]byte("late\n")); !errors.Is(err, ErrSinkClosed) {
+		t.Fatalf("write after close: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.bodies) != 1 || server.throttle != 0 {
+		t.Fatalf("bodies %q, throttle left %d", server.bodies, server.throttle)
+	}
+	h := server.headers[0]
+	if h.Get("Authorization") != "Bearer t0ken" || h.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("headers %v", h)
+	}
+	lines := strings.Split(strings.TrimSuffix(server.bodies[0], "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("lines %q", lines)
+	}
+	for i, line := range lines {
+		var entry map[string]interface{}
+		if err := stdjson.Unmarshal([]byte(line), &entry); err != nil || entry["i"] != float64(i) {
+			t.Fatalf("line %d %q: %v", i, line, err)
+		}
+	}
+}
+
+func TestHTTPSinkFormats(t *testing.T) {
+	server := &httpSinkServer{}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	for _, cfg := range []HTTPSinkConfig{
+		{Format: HTTPBodyJSONArray},
+		{Format: HTTPBodyTemplate, Template: `{"n":{{.Count}},"logs":[{{join .Lines ","}}]}`},
+		// MaxBatchBytes 很小时每条一个批次
+		{Format: HTTPBodyJSONArray, MaxBatchBytes: 1},
+	} {
+		cfg.URL, cfg.FlushInterval = srv.URL, time.Hour
+		sink, err := NewHTTPSink(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink.Write([]byte(`{"a":1}` + "\n"))
+		sink.Write([]byte(`{"a":2}` + "\n"))
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	want := []string{`[{"a":1},{"a":2}]`, `{"n":2,"logs":[{"a":1},{"a":2}]}`, `[{"a":1}]`, `[{"a":2}]`}
+	if strings.Join(server.bodies, "|") != strings.Join(want, "|") {
+		t.Fatalf("bodies %q", server.bodies)
+	}
+}
+
+func TestHTTPSinkRetryAfterCapped(t *testing.T) {
+	server := &httpSinkServer{throttle: 1, retryAfter: "3600"}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	sink, err := NewHTTPSink(HTTPSinkConfig{URL: srv.URL, FlushInterval: time.Hour, MaxBackoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	sink.Write([]byte(`{"a":1}` + "\n"))
+	start := time.Now()
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("Retry-After not capped, sync took %s", d)
+	}
+}
+
+// stallHandler 收到 release 前不返回
+type stallHandler struct {
+	release chan struct{}
+}
+
+func (h stallHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	<-h.release
+}
+
+func TestHTTPSinkQueueOverflow(t *testing.T) {
+	h := stallHandler{release: make(chan struct{})}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	sink, err := NewHTTPSink(HTTPSinkConfig{URL: srv.URL, MaxBatchBytes: 1, QueueSize: 1, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 下游卡住时 Write 不等待, 超出队列的批次被丢弃
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := sink.Write([]byte(`{"a":1}` + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("writes blocked for %s", d)
+	}
+	if n := sink.Dropped(); n < 3 {
+		t.Fatalf("dropped %d", n)
+	}
+	close(h.release)
+	if err := sink.Close(); err == nil || !strings.Contains(err.Error(), "dropped") {
+		t.Fatalf("close: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package log
+
+import (
+	"encoding/binary"
+	stdjson "encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultJournaldSocket journald native protocol socket
+const DefaultJournaldSocket = "/run/systemd/journal/socket"
+
+// JournaldConfig NewJournaldCore 的配置
+type JournaldConfig struct {
+	SocketPath string // 默认 DefaultJournaldSocket
+	Identifier string // SYSLOG_IDENTIFIER, 默认进程名
+	// FieldPrefix 加在 zap field 转换后的名字前, 避免与 journald 自带字段冲突, 如 "APP_"
+	FieldPrefix string
+}
+
+// JournalFieldName zap field key 转成 journal 字段名:
+// 大写, 非 [A-Z0-9_] 替换为 _, 不能以 _ 或数字开头, 最长 64
+func JournalFieldName(key string) string {
+	b := make([]byte, 0, len(key))
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+			b = append(b, c-'a'+'A')
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_':
+			b = append(b, c)
+		default:
+			b = append(b, '_')
+		}
+	}
+	name := strings.TrimLeft(string(b), "_")
+	if name == "" {
+		return ""
+	}
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "F_" + name
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// appendJournalField native protocol: 单行值 "KEY=value\n",
+// 含换行的值 "KEY\n" + 64 位小端长度 + value + "\n"
+func appendJournalField(b []byte, key, value string) []byte {
+	if !strings.ContainsRune(value, '\n') {
+		b = append(b, key...)
+		b = append(b, '=')
+		b = append(b, value...)
+		return append(b, '\n')
+	}
+	b = append(b, key...)
+	b = append(b, '\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	b = append(b, size[:]...)
+	b = append(b, value...)
+	return append(b, '\n')
+}
+
+// journalValue 把 MapObjectEncoder 里的值转成字符串, 其他类型用 JSON
+func journalValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case bool:
+		return strconv.FormatBool(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case uint64:
+		return strconv.FormatUint(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case time.Duration:
+		return val.String()
+	case fmt.Stringer:
+		return val.String()
+	}
+	if data, err := stdjson.Marshal(v); err == nil {
+		return string(data)
+	}
+	return fmt.Sprint(v)
+}
+
+// encodeJournalEntry 生成一条 native protocol 报文
+func encodeJournalEntry(cfg *JournaldConfig, ent zapcore.Entry, fields []zapcore.Field) []byte {
+	b := make([]byte, 0, 256)
+	b = appendJournalField(b, "MESSAGE", ent.Message)
+	b = appendJournalField(b, "PRIORITY", strconv.Itoa(SyslogSeverity(ent.Level)))
+	b = appendJournalField(b, "SYSLOG_IDENTIFIER", cfg.Identifier)
+	if ent.LoggerName != "" {
+		b = appendJournalField(b, "LOGGER", ent.LoggerName)
+	}
+	if ent.Caller.Defined {
+		b = appendJournalField(b, "CODE_FILE", ent.Caller.File)
+		b = appendJournalField(b, "CODE_LINE", strconv.Itoa(ent.Caller.Line))
+		if ent.Caller.Function != "" {
+			b = appendJournalField(b, "CODE_FUNC", ent.Caller.Function)
+		}
+	}
+	if ent.Stack != "" {
+		b = appendJournalField(b, "STACKTRACE", ent.Stack)
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	for i := range fields {
+		fields[i].AddTo(enc)
+	}
+	// 按 field 顺序输出, MapObjectEncoder 本身无序
+	seen := make(map[string]struct{}, len(fields))
+	for _, f := range fields {
+		if _, ok := seen[f.Key]; ok {
+			continue
+		}
+		seen[f.Key] = struct{}{}
+		v, ok := enc.Fields[f.Key]
+		if !ok {
+			continue
+		}
+		name := JournalFieldName(f.Key)
+		if name == "" {
+			continue
+		}
+		b = appendJournalField(b, cfg.FieldPrefix+name, journalValue(v))
+	}
+	return b
+}
@@ -0,0 +1,116 @@
+package log
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type journaldCore struct {
+	zapcore.LevelEnabler
+	cfg    *JournaldConfig
+	fields []zapcore.Field
+	conn   *journaldConn
+}
+
+// journaldConn 未连接的 unixgram socket, 多个 clone 共用
+type journaldConn struct {
+	mu   sync.Mutex
+	conn *net.UnixConn
+	addr *net.UnixAddr
+}
+
+// NewJournaldCore 通过 journald native protocol 发送, zap field 转为大写的 journal 字段,
+// 超过 socket 报文上限的条目写入临时文件后传递文件描述符
+func NewJournaldCore(cfg JournaldConfig, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	if cfg.SocketPath == "" {
+		cfg.SocketPath = DefaultJournaldSocket
+	}
+	if cfg.Identifier == "" {
+		cfg.Identifier = filepath.Base(os.Args[0])
+	}
+	cfg.FieldPrefix = JournalFieldName(cfg.FieldPrefix)
+	if cfg.FieldPrefix != "" && cfg.FieldPrefix[len(cfg.FieldPrefix)-1] != '_' {
+		cfg.FieldPrefix += "_"
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldCore{
+		LevelEnabler: enab,
+		cfg:          &cfg,
+		conn: &journaldConn{
+			conn: conn,
+			addr: &net.UnixAddr{Name: cfg.SocketPath, Net: "unixgram"},
+		},
+	}, nil
+}
+
+func (c *journaldCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+	return &clone
+}
+
+func (c *journaldCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *journaldCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	all := fields
+	if len(c.fields) > 0 {
+		all = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+		all = append(all, c.fields...)
+		all = append(all, fields...)
+	}
+	return c.conn.send(encodeJournalEntry(c.cfg, ent, all))
+}
+
+func (c *journaldCore) Sync() error {
+	return nil
+}
+
+func (jc *journaldConn) send(data []byte) error {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	_, _, err := jc.conn.WriteMsgUnix(data, nil, jc.addr)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, syscall.EMSGSIZE) && !errors.Is(err, syscall.ENOBUFS) {
+		return err
+	}
+	return jc.sendFile(data)
+}
+
+// sendFile 大条目: 写入已 unlink 的临时文件, 通过 SCM_RIGHTS 传 fd
+func (jc *journaldConn) sendFile(data []byte) error {
+	dir := "/dev/shm"
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		dir = os.TempDir()
+	}
+	f, err := os.CreateTemp(dir, "journal.")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	_, _, err = jc.conn.WriteMsgUnix(nil, syscall.UnixRights(int(f.Fd())), jc.addr)
+	return err
+}
@@ -0,0 +1,117 @@
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// parseJournal 解析 native protocol 报文
+func parseJournal(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			t.Fatalf("truncated entry %q", data)
+		}
+		line := data[:nl]
+		if eq := bytes.IndexByte(line, '='); eq >= 0 {
+			fields[string(line[:eq])] = string(line[eq+1:])
+			data = data[nl+1:]
+			continue
+		}
+		size := binary.LittleEndian.Uint64(data[nl+1 : nl+9])
+		fields[string(line)] = string(data[nl+9 : nl+9+int(size)])
+		data = data[nl+9+int(size)+1:]
+	}
+	return fields
+}
+
+func TestJournaldCore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	core, err := NewJournaldCore(JournaldConfig{SocketPath: path, Identifier: "demo", FieldPrefix: "app"}, zapcore.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(core).With(zap.String("req-id", "r1"))
+	logger.Warn("hello\nworld", zap.Int("count", 3), zap.Any("obj", map[string]int{"a": 1}))
+
+	buf := make([]byte, 64*1024)
+	_ = server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := parseJournal(t, buf[:n])
+	want := map[string]string{
+		"MESSAGE":           "hello\nworld",
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "demo",
+		"APP_REQ_ID":        "r1",
+		"APP_COUNT":         "3",
+		"APP_OBJ":           `{"a":1}`,
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("%s = %q, want %q", k, fields[k], v)
+		}
+	}
+}
+
+func TestJournaldCoreLargeEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	core, err := NewJournaldCore(JournaldConfig{SocketPath: path}, zapcore.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("x", 4*1024*1024)
+	zap.New(core).Info(large)
+
+	buf := make([]byte, 1024)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_ = server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, oobn, _, _, err := server.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("control messages %v, %v", msgs, err)
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		t.Fatalf("fds %v, %v", fds, err)
+	}
+	f := os.NewFile(uintptr(fds[0]), "journal")
+	defer f.Close()
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields := parseJournal(t, data); fields["MESSAGE"] != large {
+		t.Fatalf("MESSAGE length = %d", len(fields["MESSAGE"]))
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package log
+
+import (
+	"errors"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// NewJournaldCore journald 只在 linux 上可用
+func NewJournaldCore(cfg JournaldConfig, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	return nil, errors.New("log: journald is only supported on linux")
+}
@@ -0,0 +1,180 @@
+package log
+
+import (
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// 运行时调整等级: NewLogger 以 AppName, NewZapLogger 以 WithLevelName 的名字注册 AtomicLevel,
+// LevelHandler 挂到 HTTP 上, ReloadLevels 在配置重载时调用
+var levels = struct {
+	sync.RWMutex
+	m map[string]zap.AtomicLevel
+}{m: make(map[string]zap.AtomicLevel)}
+
+// RegisterLevel 按名字注册等级, name 为空或已注册了另一个等级时返回错误, 需要先 UnregisterLevel
+func RegisterLevel(name string, lvl zap.AtomicLevel) error {
+	if name == "" {
+		return errors.New("log: level name is empty")
+	}
+	levels.Lock()
+	defer levels.Unlock()
+	if old, ok := levels.m[name]; ok && old != lvl {
+		return fmt.Errorf("log: level %q is already registered", name)
+	}
+	levels.m[name] = lvl
+	return nil
+}
+
+// UnregisterLevel 取消注册
+func UnregisterLevel(name string) {
+	levels.Lock()
+	delete(levels.m, name)
+	levels.Unlock()
+}
+
+// levelRegistration logger Close 时取消 WithLevelName 的注册, 之后可以用同一个名字创建 logger
+type levelRegistration struct {
+	name string
+	lvl  zap.AtomicLevel
+}
+
+func (r levelRegistration) Close() error {
+	levels.Lock()
+	if levels.m[r.name] == r.lvl {
+		delete(levels.m, r.name)
+	}
+	levels.Unlock()
+	return nil
+}
+
+// LookupLevel 按名字取已注册的等级
+func LookupLevel(name string) (zap.AtomicLevel, bool) {
+	levels.RLock()
+	defer levels.RUnlock()
+	lvl, ok := levels.m[name]
+	return lvl, ok
+}
+
+// Levels 当前所有已注册的等级
+func Levels() map[string]zapcore.Level {
+	levels.RLock()
+	defer levels.RUnlock()
+	ret := make(map[string]zapcore.Level, len(levels.m))
+	for name, lvl := range levels.m {
+		ret[name] = lvl.Level()
+	}
+	return ret
+}
+
+// SetLevelByName 设置一个已注册的等级, name 为空或 "*" 时设置全部
+func SetLevelByName(name, text string) error {
+	var lvl zapcore.Level
+	if err := lvl.UnmarshalText([]byte(text)); err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+	levels.RLock()
+	defer levels.RUnlock()
+	if name == "" || name == "*" {
+		for _, al := range levels.m {
+			al.SetLevel(lvl)
+		}
+		return nil
+	}
+	al, ok := levels.m[name]
+	if !ok {
+		return fmt.Errorf("log: level %q is not registered", name)
+	}
+	al.SetLevel(lvl)
+	return nil
+}
+
+// ReloadLevels 配置重载的入口, cfg 为 名字 -> 等级, "*" 先作用于全部再由具体名字覆盖.
+// 先校验全部, 有错误时不做任何修改
+func ReloadLevels(cfg map[string]string) error {
+	levels.RLock()
+	defer levels.RUnlock()
+	parsed := make(map[string]zapcore.Level, len(cfg))
+	for name, text := range cfg {
+		var lvl zapcore.Level
+		if err := lvl.UnmarshalText([]byte(text)); err != nil {
+			return fmt.Errorf("log: reload level %q: %w", name, err)
+		}
+		if _, ok := levels.m[name]; !ok && name != "*" {
+			return fmt.Errorf("log: level %q is not registered", name)
+		}
+		parsed[name] = lvl
+	}
+	if lvl, ok := parsed["*"]; ok {
+		for _, al := range levels.m {
+			al.SetLevel(lvl)
+		}
+	}
+	for name, lvl := range parsed {
+		if name != "*" {
+			levels.m[name].SetLevel(lvl)
+		}
+	}
+	return nil
+}
+
+type levelPayload struct {
+	Name  string `json:"name,omitempty"`
+	Level string `json:"level"`
+}
+
+// LevelHandler 查看和修改已注册的等级:
+//
+//	GET /level                 {"levels":{"app":"info"}}
+//	PUT /level {"name":"app","level":"debug"}, name 省略时修改全部
+//
+// PUT 也接受 ?name=app&level=debug
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			req := levelPayload{Name: r.URL.Query().Get("name"), Level: r.URL.Query().Get("level")}
+			if req.Level == "" {
+				if err := stdjson.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeLevelError(w, http.StatusBadRequest, err)
+					return
+				}
+			}
+			if req.Name != "" && req.Name != "*" {
+				if _, ok := LookupLevel(req.Name); !ok {
+					writeLevelError(w, http.StatusNotFound, fmt.Errorf("level %q is not registered", req.Name))
+					return
+				}
+			}
+			if err := SetLevelByName(req.Name, req.Level); err != nil {
+				writeLevelError(w, http.StatusBadRequest, err)
+				return
+			}
+		default:
+			writeLevelError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		out := make(map[string]string)
+		for name, lvl := range Levels() {
+			out[name] = lvl.String()
+		}
+		_ = stdjson.NewEncoder(w).Encode(struct {
+			Levels map[string]string `json:"levels"`
+		}{out})
+	})
+}
+
+func writeLevelError(w http.ResponseWriter, code int, err error) {
+	w.WriteHeader(code)
+	_ = stdjson.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
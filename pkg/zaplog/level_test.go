@@ -0,0 +1,116 @@
+package log
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLevelHandler(t *testing.T) {
+	out := &syncBuffer{}
+	l := NewZapLoggerWithOptions(nil, WithLevelName("test-handler"), WithFallbackWriters(out))
+	defer UnregisterLevel("test-handler")
+	l.Debug("before")
+
+	h := LevelHandler()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/level", strings.NewReader(`{"name":"test-handler","level":"debug"}`)))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"test-handler":"debug"`) {
+		t.Fatalf("put %d %s", rec.Code, rec.Body.String())
+	}
+	l.Debug("after")
+	if s := out.String(); strings.Contains(s, "before") || !strings.Contains(s, "after") {
+		t.Fatalf("output %q", s)
+	}
+
+	for target, code := range map[string]int{
+		"/level?name=missing&level=info":      404,
+		"/level?name=test-handler&level=loud": 400,
+	} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("PUT", target, nil))
+		if rec.Code != code {
+			t.Fatalf("%s: %d %s", target, rec.Code, rec.Body.String())
+		}
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/level", nil))
+	if !strings.Contains(rec.Body.String(), `"test-handler":"debug"`) {
+		t.Fatalf("get %s", rec.Body.String())
+	}
+}
+
+func TestReloadLevels(t *testing.T) {
+	a, b := zap.NewAtomicLevel(), zap.NewAtomicLevel()
+	RegisterLevel("test-a", a)
+	RegisterLevel("test-b", b)
+	defer UnregisterLevel("test-a")
+	defer UnregisterLevel("test-b")
+
+	// 有一项错误时都不修改
+	if err := ReloadLevels(map[string]string{"test-a": "debug", "test-c": "info"}); err == nil || a.Level() != zapcore.InfoLevel {
+		t.Fatalf("err %v, level %s", err, a.Level())
+	}
+	if err := ReloadLevels(map[string]string{"*": "warn", "test-a": "debug"}); err != nil {
+		t.Fatal(err)
+	}
+	if a.Level() != zapcore.DebugLevel || b.Level() != zapcore.WarnLevel {
+		t.Fatalf("levels %s %s", a.Level(), b.Level())
+	}
+}
+
+func TestGetAtomicLevel(t *testing.T) {
+	lvl, ok := GetAtomicLevel()
+	if !ok {
+		t.Fatal("zaplog1 logger not initialized")
+	}
+	registered, _ := LookupLevel(appname)
+	lvl.SetLevel(zapcore.WarnLevel)
+	defer lvl.SetLevel(zapcore.DebugLevel)
+	if registered.Level() != zapcore.WarnLevel || logger.Core().Enabled(zapcore.InfoLevel) {
+		t.Fatalf("level %s", registered.Level())
+	}
+}
+
+func TestRegisterLevelDuplicate(t *testing.T) {
+	a := zap.NewAtomicLevel()
+	if err := RegisterLevel("", a); err == nil {
+		t.Fatal("empty name registered")
+	}
+	if err := RegisterLevel("test-dup", a); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterLevel("test-dup")
+	if err := RegisterLevel("test-dup", a); err != nil {
+		t.Fatalf("same level: %v", err)
+	}
+	if err := RegisterLevel("test-dup", zap.NewAtomicLevel()); err == nil {
+		t.Fatal("duplicate name replaced the registered level")
+	}
+	if got, _ := LookupLevel("test-dup"); got != a {
+		t.Fatal("registered level changed")
+	}
+
+	// 同名的 logger 不覆盖已注册的等级, Close 后名字可以再用
+	errOut := &syncBuffer{}
+	l := NewZapLoggerWithOptions(nil, WithLevelName("test-dup"), WithErrorOutput(errOut), WithFallbackWriters(&syncBuffer{}))
+	if !strings.Contains(errOut.String(), `"test-dup" is already registered`) {
+		t.Fatalf("error output %q", errOut.String())
+	}
+	l.Close()
+	if got, _ := LookupLevel("test-dup"); got != a {
+		t.Fatal("Close removed a level it did not register")
+	}
+	UnregisterLevel("test-dup")
+	l = NewZapLoggerWithOptions(nil, WithLevelName("test-dup"), WithFallbackWriters(&syncBuffer{}))
+	if _, ok := LookupLevel("test-dup"); !ok {
+		t.Fatal("level not registered")
+	}
+	l.Close()
+	if _, ok := LookupLevel("test-dup"); ok {
+		t.Fatal("level still registered after Close")
+	}
+}
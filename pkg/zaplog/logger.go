@@ -5,12 +5,10 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"reflect"
 	"time"
 	"unsafe"
 
 	jsoniter "github.com/json-iterator/go"
-	"github.com/natefinch/lumberjack"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -26,6 +24,8 @@ type key int
 
 const (
 	loggerKey key = iota
+	fieldsKey key = iota
+	metaKey   key = iota
 	maxSize       = 1000
 	maxAge        = 7
 	bufSize       = 1000 * 1000
@@ -49,7 +49,27 @@ func (bw bufwriter) Sync() error {
 }
 
 func SetContext(ctx context.Context, fields ...zapcore.Field) context.Context {
-	return context.WithValue(ctx, loggerKey, WithContext(ctx).With(fields...))
+	ctx = context.WithValue(ctx, fieldsKey, withContextFields(ctx, fields))
+	if ctxLogger := WithContext(ctx); ctxLogger != nil {
+		ctx = context.WithValue(ctx, loggerKey, ctxLogger.With(fields...))
+	}
+	return ctx
+}
+
+// contextFields 返回 SetContext 累积的 fields, 供 ZapLogOper 的 ctx 方法使用
+func contextFields(ctx context.Context) []zapcore.Field {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(fieldsKey).([]zapcore.Field)
+	return fields
+}
+
+// ContextFields SetContext 保存的 fields, 用于不读取 ctx 的 logger, 如
+//
+//	logger.With(ContextFields(ctx)...).Info("msg")
+func ContextFields(ctx context.Context) []zap.Field {
+	return contextFields(ctx)
 }
 
 func WithContext(ctx context.Context) *zap.Logger {
@@ -75,14 +95,51 @@ func NewBufWriter(n int, writer io.Writer) bufwriter {
 }
 
 func InitWithConfig(level string, filename string) {
-	if logger2 != nil {
-		return
-	}
-	jack := lumberjack.Logger{
-		Filename: filename,
+	InitWithRotation(level, filename, RotationOptions{
 		MaxSize:  maxSize, // megabytes
 		MaxAge:   maxAge,  //days
 		Compress: true,    // disabled by default
+	})
+}
+
+type initOptions struct {
+	metrics     *Metrics
+	errorGroups *ErrorGroups
+}
+
+// InitOption InitWithRotation 的可选配置
+type InitOption func(*initOptions)
+
+// InitWithMetrics 把 bufwriter 和切割文件注册到 m, 并统计日志条数, 一般传 DefaultMetrics
+func InitWithMetrics(m *Metrics) InitOption {
+	return func(opt *initOptions) {
+		opt.metrics = m
+	}
+}
+
+// InitWithErrorGroups 错误日志按指纹聚合到 g, 一般传 DefaultErrorGroups
+func InitWithErrorGroups(g *ErrorGroups) InitOption {
+	return func(opt *initOptions) {
+		opt.errorGroups = g
+	}
+}
+
+// InitWithRotation 同 InitWithConfig, 可自定义切割配置
+func InitWithRotation(level string, filename string, rotation RotationOptions, opts ...InitOption) {
+	if logger2 != nil {
+		return
+	}
+	opt := &initOptions{}
+	for _, f := range opts {
+		f(opt)
+	}
+	var w io.Writer = NewRotateWriter(filename, rotation)
+	if opt.metrics != nil {
+		w = opt.metrics.Writer(filename, w)
+	}
+	bufw := NewBufWriter(bufSize, w)
+	if opt.metrics != nil {
+		opt.metrics.ObserveBufWriter(filename, bufw)
 	}
 
 	var zapLevel zapcore.Level
@@ -107,7 +164,13 @@ func InitWithConfig(level string, filename string) {
 		EncodeDuration: zapcore.SecondsDurationEncoder,
 		EncodeCaller:   zapcore.ShortCallerEncoder,
 		EncodeName:     zapcore.FullNameEncoder,
-	}), NewBufWriter(bufSize, &jack), zapLevel)
+	}), bufw, zapLevel)
+	if opt.errorGroups != nil {
+		core = zapcore.NewTee(core, opt.errorGroups.Core())
+	}
+	if opt.metrics != nil {
+		core = opt.metrics.WrapCore(core, nil)
+	}
 	logger2 = zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
 }
 
@@ -215,11 +278,7 @@ func ErrorJsonCtx(c context.Context, args interface{}) {
 	WithContext(c).Error("", getField(zap.Any("data", bytes2string(data)))...)
 }
 
+// bytes2string 零拷贝转换, 构造 reflect.StringHeader 会被 go vet 报 unsafeptr
 func bytes2string(b []byte) string {
-	sliceHeader := (*reflect.SliceHeader)(unsafe.Pointer(&b))
-	sh := reflect.StringHeader{
-		Data: sliceHeader.Data,
-		Len:  sliceHeader.Len,
-	}
-	return *(*string)(unsafe.Pointer(&sh))
+	return *(*string)(unsafe.Pointer(&b))
 }
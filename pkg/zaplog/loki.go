@@ -0,0 +1,372 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// LokiFormat push 请求体格式
+type LokiFormat int
+
+const (
+	LokiJSON     LokiFormat = iota // application/json
+	LokiProtobuf                   // snappy 压缩的 protobuf
+)
+
+const lokiPushPath = "/loki/api/v1/push"
+
+// LokiConfig NewLokiCore 的配置
+type LokiConfig struct {
+	URL    string // 如 http://loki:3100, 没有 path 时补上 /loki/api/v1/push
+	Format LokiFormat
+	// Labels 固定的 stream label, 如 {"app": "demo"}
+	Labels map[string]string
+	// LabelFields 提升为 stream label 的字段, 不再出现在日志行里, 默认 level 和 server (getField).
+	// "level" 没有同名 field 时取日志等级
+	LabelFields []string
+	TenantID    string            // X-Scope-OrgID
+	Headers     map[string]string // 额外的请求头, 如 Authorization
+	BatchSize   int               // 攒够多少条发送, 默认 100
+	BatchWait   time.Duration     // 最长等待多久发送, 默认 1s
+	QueueSize   int               // 等待发送的满批次数, 超过时丢弃新的批次, 默认 DefaultSinkQueueSize
+	MaxRetries  int               // 网络错误/429/5xx 的重试次数, 默认 3, 负数不重试
+	MinBackoff  time.Duration     // 默认 500ms, 每次翻倍, 有 Retry-After 时使用它 (不超过 MaxBackoff)
+	MaxBackoff  time.Duration     // 默认 5s
+	Timeout     time.Duration     // 单次请求超时, 默认 10s
+	Client      *http.Client
+	// Encoder 日志行的编码, 默认 JSON, 时间由 Loki entry 的时间戳表示
+	Encoder zapcore.Encoder
+}
+
+type lokiCore struct {
+	zapcore.LevelEnabler
+	fields []zapcore.Field
+	client *lokiClient
+}
+
+// NewLokiCore 批量推送到 Loki push API, 返回的 core 实现 io.Closer,
+// Close 发送剩余日志并停止定时发送
+func NewLokiCore(cfg LokiConfig, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("log: invalid loki url %q", cfg.URL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = lokiPushPath
+	}
+	cfg.URL = u.String()
+	if cfg.LabelFields == nil {
+		cfg.LabelFields = []string{"level", "server"}
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.BatchWait <= 0 {
+		cfg.BatchWait = time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultSinkQueueSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 5 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	client := &lokiClient{cfg: &cfg}
+	for _, k := range cfg.LabelFields {
+		if k == "level" {
+			client.levelLabel = true
+		}
+	}
+	if cfg.Encoder == nil {
+		levelKey := "level"
+		if client.levelLabel {
+			// level 已经是 label, 日志行里不再重复
+			levelKey = ""
+		}
+		cfg.Encoder = zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+			LevelKey:       levelKey,
+			NameKey:        "logger",
+			CallerKey:      "caller",
+			MessageKey:     "msg",
+			StacktraceKey:  "stacktrace",
+			LineEnding:     "\n",
+			EncodeLevel:    zapcore.LowercaseLevelEncoder,
+			EncodeDuration: zapcore.SecondsDurationEncoder,
+			EncodeCaller:   zapcore.ShortCallerEncoder,
+		})
+	}
+	client.batch = newBatcher(batcherConfig{
+		MaxItems:  cfg.BatchSize,
+		QueueSize: cfg.QueueSize,
+		Interval:  cfg.BatchWait,
+	}, client.send)
+	return &lokiCore{LevelEnabler: enab, client: client}, nil
+}
+
+func (c *lokiCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+	return &clone
+}
+
+func (c *lokiCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *lokiCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	cfg := c.client.cfg
+	labels := make(map[string]string, len(cfg.Labels)+len(cfg.LabelFields))
+	for k, v := range cfg.Labels {
+		labels[lokiLabelName(k)] = v
+	}
+	if c.client.levelLabel {
+		labels["level"] = ent.Level.String()
+	}
+
+	line := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	for _, group := range [][]zapcore.Field{c.fields, fields} {
+		for _, f := range group {
+			if !c.client.isLabel(f.Key) {
+				line = append(line, f)
+				continue
+			}
+			enc := zapcore.NewMapObjectEncoder()
+			f.AddTo(enc)
+			if v, ok := enc.Fields[f.Key]; ok {
+				labels[lokiLabelName(f.Key)] = journalValue(v)
+			}
+		}
+	}
+	buf, err := cfg.Encoder.EncodeEntry(ent, line)
+	if err != nil {
+		return err
+	}
+	text := strings.TrimSuffix(buf.String(), "\n")
+	buf.Free()
+	return c.client.batch.add(lokiEntry{labels: labels, ts: ent.Time, line: text}, 0)
+}
+
+func (c *lokiCore) Sync() error {
+	return c.client.batch.flush()
+}
+
+func (c *lokiCore) Close() error {
+	return c.client.batch.close()
+}
+
+type lokiEntry struct {
+	labels map[string]string
+	ts     time.Time
+	line   string
+}
+
+type lokiStream struct {
+	labels  map[string]string
+	entries []lokiEntry
+}
+
+type lokiClient struct {
+	cfg        *LokiConfig
+	levelLabel bool
+	batch      *batcher
+}
+
+func (cl *lokiClient) isLabel(key string) bool {
+	for _, k := range cl.cfg.LabelFields {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// send 按 label 分成 stream 后推送一个批次
+func (cl *lokiClient) send(ctx context.Context, items []interface{}) error {
+	streams := make(map[string]*lokiStream)
+	for _, item := range items {
+		e := item.(lokiEntry)
+		key := lokiLabelString(e.labels)
+		s, ok := streams[key]
+		if !ok {
+			s = &lokiStream{labels: e.labels}
+			streams[key] = s
+		}
+		s.entries = append(s.entries, e)
+	}
+	keys := make([]string, 0, len(streams))
+	for k := range streams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var (
+		body        []byte
+		contentType string
+		err         error
+	)
+	if cl.cfg.Format == LokiProtobuf {
+		body, contentType = snappyEncode(encodeLokiProto(keys, streams)), "application/x-protobuf"
+	} else if body, err = encodeLokiJSON(keys, streams); err != nil {
+		return err
+	} else {
+		contentType = "application/json"
+	}
+	policy := retryPolicy{maxRetries: cl.cfg.MaxRetries, minBackoff: cl.cfg.MinBackoff, maxBackoff: cl.cfg.MaxBackoff}
+	if err := policy.do(ctx, func() (bool, time.Duration, error) {
+		return cl.pushOnce(ctx, body, contentType)
+	}); err != nil {
+		return fmt.Errorf("log: loki push: %w", err)
+	}
+	return nil
+}
+
+// pushOnce 返回是否值得重试, 以及服务端要求的等待时间
+func (cl *lokiClient) pushOnce(ctx context.Context, body []byte, contentType string) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, cl.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cl.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if cl.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", cl.cfg.TenantID)
+	}
+	for k, v := range cl.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := cl.cfg.Client.Do(req)
+	if err != nil {
+		return true, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return false, 0, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, retryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+}
+
+// lokiLabelName label 名只能是 [a-zA-Z_][a-zA-Z0-9_]*
+func lokiLabelName(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= '0' && c <= '9' && i > 0) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// lokiLabelString prometheus 格式的 label 集合, 如 {app="demo", level="info"}
+func lokiLabelString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, k := range names {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(labels[k]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func encodeLokiJSON(keys []string, streams map[string]*lokiStream) ([]byte, error) {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	req := struct {
+		Streams []stream `json:"streams"`
+	}{Streams: make([]stream, 0, len(keys))}
+	for _, k := range keys {
+		s := streams[k]
+		values := make([][2]string, 0, len(s.entries))
+		for _, e := range s.entries {
+			values = append(values, [2]string{strconv.FormatInt(e.ts.UnixNano(), 10), e.line})
+		}
+		req.Streams = append(req.Streams, stream{Stream: s.labels, Values: values})
+	}
+	return stdjson.Marshal(req)
+}
+
+// encodeLokiProto logproto.PushRequest:
+//
+//	PushRequest { repeated StreamAdapter streams = 1; }
+//	StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+func encodeLokiProto(keys []string, streams map[string]*lokiStream) []byte {
+	var req, stream, entry, ts []byte
+	for _, k := range keys {
+		stream = appendProtoBytes(stream[:0], 1, []byte(k))
+		for _, e := range streams[k].entries {
+			ts = ts[:0]
+			if sec := e.ts.Unix(); sec != 0 {
+				ts = appendProtoVarint(ts, 1, uint64(sec))
+			}
+			if nanos := e.ts.Nanosecond(); nanos != 0 {
+				ts = appendProtoVarint(ts, 2, uint64(nanos))
+			}
+			entry = appendProtoBytes(entry[:0], 1, ts)
+			entry = appendProtoBytes(entry, 2, []byte(e.line))
+			stream = appendProtoBytes(stream, 2, entry)
+		}
+		req = appendProtoBytes(req, 1, stream)
+	}
+	return req
+}
+
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	b = appendUvarint(b, uint64(field)<<3)
+	return appendUvarint(b, v)
+}
+
+func appendProtoBytes(b []byte, field int, p []byte) []byte {
+	b = appendUvarint(b, uint64(field)<<3|2)
+	b = appendUvarint(b, uint64(len(p)))
+	return append(b, p...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
@@ -0,0 +1,425 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultMetricsBuckets sink 写入耗时直方图的桶, 单位秒
+var DefaultMetricsBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+// DefaultMetrics 包级的 Metrics, 如 InitWithRotation(level, file, rotation, InitWithMetrics(DefaultMetrics))
+var DefaultMetrics = NewMetrics()
+
+// Metrics 日志量和各 writer 的健康指标, 实现 http.Handler, 输出 Prometheus text format 0.0.4:
+//
+//	zaplog_entries_total{level,logger,package}
+//	zaplog_entry_bytes_total{level,logger,package}
+//	zaplog_write_errors_total{sink}
+//	zaplog_sink_bytes_total{sink}
+//	zaplog_sink_write_duration_seconds{sink}  histogram
+//	zaplog_queue_depth{queue} / zaplog_queue_capacity{queue} / zaplog_queue_dropped_total{queue}
+//	zaplog_rotations_total{file}
+type Metrics struct {
+	buckets []float64
+
+	mu      sync.RWMutex
+	entries map[entryLabels]*entryCounter
+	errors  map[string]*uint64
+	sinks   map[string]*sinkMetrics
+	funcs   map[string]*funcMetric // key 为 name + label
+}
+
+type entryLabels struct {
+	level, logger, pkg string
+}
+
+type entryCounter struct {
+	entries, bytes uint64
+}
+
+type sinkMetrics struct {
+	bytes uint64
+
+	mu     sync.Mutex
+	counts []uint64 // 与 buckets 对应, 非累计
+	count  uint64
+	sum    float64
+}
+
+// funcMetric 采集时调用 fn 的指标
+type funcMetric struct {
+	name, help, typ, labelName, labelValue string
+	fn                                     func() float64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buckets: DefaultMetricsBuckets,
+		entries: make(map[entryLabels]*entryCounter),
+		errors:  make(map[string]*uint64),
+		sinks:   make(map[string]*sinkMetrics),
+		funcs:   make(map[string]*funcMetric),
+	}
+}
+
+// WrapCore 统计经过 core 的日志条数, enc 不为 nil 时按 enc 编码后的长度统计字节数 (会多编码一次)
+func (m *Metrics) WrapCore(core zapcore.Core, enc zapcore.Encoder) zapcore.Core {
+	return &metricsCore{Core: core, m: m, enc: enc}
+}
+
+// Option 用于 NewJSONLogger / NewLogger 返回的 *zap.Logger: logger.WithOptions(m.Option(enc))
+func (m *Metrics) Option(enc zapcore.Encoder) zap.Option {
+	return zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return m.WrapCore(c, enc)
+	})
+}
+
+// Writer 统计写入 w 的字节数, 耗时和错误, name 为 sink label.
+// w 为 *RotateWriter 时同时统计切割次数
+func (m *Metrics) Writer(name string, w io.Writer) zapcore.WriteSyncer {
+	if rw, ok := w.(*RotateWriter); ok {
+		m.ObserveRotateWriter(name, rw)
+	}
+	return &metricsWriter{m: m, name: name, w: w, sink: m.sink(name)}
+}
+
+// ObserveRotateWriter 注册切割次数
+func (m *Metrics) ObserveRotateWriter(name string, w *RotateWriter) {
+	m.observeFunc("zaplog_rotations_total", "Log file rotations.", "counter", "file", name, func() float64 {
+		return float64(w.Rotations())
+	})
+}
+
+// ObserveBufWriter 注册 NewBufWriter 返回的 writer 的队列长度, w 不是 bufwriter 时返回 false
+func (m *Metrics) ObserveBufWriter(name string, w io.Writer) bool {
+	bw, ok := w.(bufwriter)
+	if !ok {
+		return false
+	}
+	m.ObserveQueue(name, func() int { return len(bw.bs) }, func() int { return cap(bw.bs) }, nil)
+	return true
+}
+
+// ObserveQueue 注册任意队列, dropped 可以为 nil
+func (m *Metrics) ObserveQueue(name string, depth, capacity func() int, dropped func() uint64) {
+	m.observeFunc("zaplog_queue_depth", "Entries waiting in the queue.", "gauge", "queue", name, func() float64 {
+		return float64(depth())
+	})
+	m.observeFunc("zaplog_queue_capacity", "Queue capacity.", "gauge", "queue", name, func() float64 {
+		return float64(capacity())
+	})
+	if dropped != nil {
+		m.observeFunc("zaplog_queue_dropped_total", "Entries dropped because the queue was full.", "counter", "queue", name, func() float64 {
+			return float64(dropped())
+		})
+	}
+}
+
+func (m *Metrics) observeFunc(name, help, typ, labelName, labelValue string, fn func() float64) {
+	m.mu.Lock()
+	m.funcs[name+"\x00"+labelValue] = &funcMetric{name: name, help: help, typ: typ, labelName: labelName, labelValue: labelValue, fn: fn}
+	m.mu.Unlock()
+}
+
+// observeEntry size < 0 表示不统计字节数
+func (m *Metrics) observeEntry(ent zapcore.Entry, size int) {
+	key := entryLabels{level: ent.Level.String(), logger: ent.LoggerName, pkg: callerPackage(ent.Caller)}
+	m.mu.RLock()
+	c, ok := m.entries[key]
+	m.mu.RUnlock()
+	if !ok {
+		m.mu.Lock()
+		if c, ok = m.entries[key]; !ok {
+			c = &entryCounter{}
+			m.entries[key] = c
+		}
+		m.mu.Unlock()
+	}
+	atomic.AddUint64(&c.entries, 1)
+	if size > 0 {
+		atomic.AddUint64(&c.bytes, uint64(size))
+	}
+}
+
+func (m *Metrics) writeError(sink string) {
+	m.mu.RLock()
+	c, ok := m.errors[sink]
+	m.mu.RUnlock()
+	if !ok {
+		m.mu.Lock()
+		if c, ok = m.errors[sink]; !ok {
+			c = new(uint64)
+			m.errors[sink] = c
+		}
+		m.mu.Unlock()
+	}
+	atomic.AddUint64(c, 1)
+}
+
+func (m *Metrics) sink(name string) *sinkMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sinks[name]
+	if !ok {
+		s = &sinkMetrics{counts: make([]uint64, len(m.buckets))}
+		m.sinks[name] = s
+	}
+	return s
+}
+
+func (s *sinkMetrics) observe(buckets []float64, d time.Duration) {
+	v := d.Seconds()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	s.sum += v
+	if i := sort.SearchFloat64s(buckets, v); i < len(buckets) {
+		s.counts[i]++
+	}
+}
+
+// callerPackage 取 caller 函数的包路径, 没有函数名时用文件所在目录
+func callerPackage(caller zapcore.EntryCaller) string {
+	if !caller.Defined {
+		return ""
+	}
+	if fn := caller.Function; fn != "" {
+		slash := strings.LastIndexByte(fn, '/')
+		if dot := strings.IndexByte(fn[slash+1:], '.'); dot >= 0 {
+			return fn[:slash+1+dot]
+		}
+		return fn
+	}
+	if i := strings.LastIndexByte(caller.File, '/'); i >= 0 {
+		return caller.File[:i]
+	}
+	return ""
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// WriteTo 输出 Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	m.mu.RLock()
+	entryKeys := make([]entryLabels, 0, len(m.entries))
+	for k := range m.entries {
+		entryKeys = append(entryKeys, k)
+	}
+	entries := m.entries
+	errorKeys := sortedKeys(m.errors)
+	sinkKeys := make([]string, 0, len(m.sinks))
+	for k := range m.sinks {
+		sinkKeys = append(sinkKeys, k)
+	}
+	sort.Strings(sinkKeys)
+	funcs := make([]*funcMetric, 0, len(m.funcs))
+	for _, f := range m.funcs {
+		funcs = append(funcs, f)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(entryKeys, func(i, j int) bool {
+		a, b := entryKeys[i], entryKeys[j]
+		if a.level != b.level {
+			return a.level < b.level
+		}
+		if a.logger != b.logger {
+			return a.logger < b.logger
+		}
+		return a.pkg < b.pkg
+	})
+	m.mu.RLock()
+	writeHeader(&buf, "zaplog_entries_total", "Log entries written.", "counter")
+	for _, k := range entryKeys {
+		writeSample(&buf, "zaplog_entries_total", entryLabelPairs(k), float64(atomic.LoadUint64(&entries[k].entries)))
+	}
+	writeHeader(&buf, "zaplog_entry_bytes_total", "Encoded bytes of log entries.", "counter")
+	for _, k := range entryKeys {
+		writeSample(&buf, "zaplog_entry_bytes_total", entryLabelPairs(k), float64(atomic.LoadUint64(&entries[k].bytes)))
+	}
+	writeHeader(&buf, "zaplog_write_errors_total", "Failed writes per sink.", "counter")
+	for _, k := range errorKeys {
+		writeSample(&buf, "zaplog_write_errors_total", []string{"sink", k}, float64(atomic.LoadUint64(m.errors[k])))
+	}
+	sinks := make([]*sinkMetrics, len(sinkKeys))
+	for i, k := range sinkKeys {
+		sinks[i] = m.sinks[k]
+	}
+	m.mu.RUnlock()
+
+	writeHeader(&buf, "zaplog_sink_bytes_total", "Bytes written per sink.", "counter")
+	for i, k := range sinkKeys {
+		writeSample(&buf, "zaplog_sink_bytes_total", []string{"sink", k}, float64(atomic.LoadUint64(&sinks[i].bytes)))
+	}
+	writeHeader(&buf, "zaplog_sink_write_duration_seconds", "Write latency per sink.", "histogram")
+	for i, k := range sinkKeys {
+		s := sinks[i]
+		s.mu.Lock()
+		var cum uint64
+		for j, le := range m.buckets {
+			cum += s.counts[j]
+			writeSample(&buf, "zaplog_sink_write_duration_seconds_bucket", []string{"sink", k, "le", formatFloat(le)}, float64(cum))
+		}
+		writeSample(&buf, "zaplog_sink_write_duration_seconds_bucket", []string{"sink", k, "le", "+Inf"}, float64(s.count))
+		writeSample(&buf, "zaplog_sink_write_duration_seconds_sum", []string{"sink", k}, s.sum)
+		writeSample(&buf, "zaplog_sink_write_duration_seconds_count", []string{"sink", k}, float64(s.count))
+		s.mu.Unlock()
+	}
+
+	// 同名的 func 指标放在一起
+	sort.Slice(funcs, func(i, j int) bool {
+		if funcs[i].name != funcs[j].name {
+			return funcs[i].name < funcs[j].name
+		}
+		return funcs[i].labelValue < funcs[j].labelValue
+	})
+	for i, f := range funcs {
+		if i == 0 || funcs[i-1].name != f.name {
+			writeHeader(&buf, f.name, f.help, f.typ)
+		}
+		writeSample(&buf, f.name, []string{f.labelName, f.labelValue}, f.fn())
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+func sortedKeys(m map[string]*uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func entryLabelPairs(k entryLabels) []string {
+	return []string{"level", k.level, "logger", k.logger, "package", k.pkg}
+}
+
+func writeHeader(buf *bytes.Buffer, name, help, typ string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeSample labels 为 name, value 交替
+func writeSample(buf *bytes.Buffer, name string, labels []string, v float64) {
+	buf.WriteString(name)
+	if len(labels) > 0 {
+		buf.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(labels[i])
+			buf.WriteString(`="`)
+			buf.WriteString(escapeLabelValue(labels[i+1]))
+			buf.WriteByte('"')
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(v))
+	buf.WriteByte('\n')
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type metricsCore struct {
+	zapcore.Core
+	m   *Metrics
+	enc zapcore.Encoder
+}
+
+func (c *metricsCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &metricsCore{Core: c.Core.With(fields), m: c.m}
+	if c.enc != nil {
+		clone.enc = c.enc.Clone()
+		for i := range fields {
+			fields[i].AddTo(clone.enc)
+		}
+	}
+	return clone
+}
+
+func (c *metricsCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *metricsCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	size := -1
+	if c.enc != nil {
+		if buf, err := c.enc.EncodeEntry(ent, fields); err == nil {
+			size = buf.Len()
+			buf.Free()
+		}
+	}
+	c.m.observeEntry(ent, size)
+	return c.Core.Write(ent, fields)
+}
+
+type metricsWriter struct {
+	m    *Metrics
+	name string
+	w    io.Writer
+	sink *sinkMetrics
+}
+
+func (w *metricsWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.w.Write(p)
+	w.sink.observe(w.m.buckets, time.Since(start))
+	atomic.AddUint64(&w.sink.bytes, uint64(n))
+	if err != nil {
+		w.m.writeError(w.name)
+	}
+	return n, err
+}
+
+func (w *metricsWriter) Sync() error {
+	if s, ok := w.w.(zapcore.WriteSyncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (w *metricsWriter) Close() error {
+	if c, ok := ownedCloser(w.w); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package log
+
+import (
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+func (failWriter) Sync() error                 { return nil }
+
+func TestMetricsExposition(t *testing.T) {
+	m := NewMetrics()
+	out := &syncBuffer{}
+	rotate := NewRotateWriter(filepath.Join(t.TempDir(), "app.log"), RotationOptions{MaxSize: 1})
+	defer rotate.Close()
+
+	l := NewZapLoggerWithOptions(nil,
+		WithWriteMode(WriteModeAsync),
+		WithMetrics(m, "main"),
+		WithFallbackWriters(m.Writer("stdout", out)),
+		WithLevelWriters(zapcore.ErrorLevel, failWriter{}, m.Writer("app.log", rotate)),
+	)
+	l.Info("hello")
+	l.Info("hello")
+	l.Error("boom")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rotate.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("content type %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE zaplog_entries_total counter\n",
+		`zaplog_entries_total{level="info",logger="",package=""} 2` + "\n",
+		`zaplog_entries_total{level="error",logger="",package=""} 1` + "\n",
+		`zaplog_write_errors_total{sink="main"} 1` + "\n",
+		"# TYPE zaplog_sink_write_duration_seconds histogram\n",
+		`zaplog_sink_write_duration_seconds_bucket{sink="stdout",le="+Inf"} 2` + "\n",
+		`zaplog_sink_write_duration_seconds_count{sink="app.log"} 1` + "\n",
+		`zaplog_queue_capacity{queue="main"} `,
+		`zaplog_queue_depth{queue="main"} 0` + "\n",
+		`zaplog_queue_dropped_total{queue="main"} 0` + "\n",
+		`zaplog_rotations_total{file="app.log"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in\n%s", want, body)
+		}
+	}
+	if !strings.Contains(body, `zaplog_sink_bytes_total{sink="stdout"} `+formatFloat(float64(len(out.String())))) {
+		t.Errorf("sink bytes do not match %d:\n%s", len(out.String()), body)
+	}
+}
+
+func TestMetricsCorePackage(t *testing.T) {
+	m := NewMetrics()
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&syncBuffer{}), zapcore.DebugLevel)
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	logger := zap.New(core, zap.AddCaller()).WithOptions(m.Option(enc)).Named(`we"ird`)
+	logger.Info("x")
+
+	var buf strings.Builder
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `zaplog_entries_total{level="info",logger="we\"ird",package="go_deep/pkg/zaplog"} 1`
+	if !strings.Contains(buf.String(), want) {
+		t.Fatalf("missing %q in\n%s", want, buf.String())
+	}
+	if strings.Contains(buf.String(), `zaplog_entry_bytes_total{level="info",logger="we\"ird",package="go_deep/pkg/zaplog"} 0`) {
+		t.Fatalf("bytes not counted:\n%s", buf.String())
+	}
+}
+
+func TestInitWithMetrics(t *testing.T) {
+	saved := logger2
+	defer func() { logger2 = saved }()
+
+	file := filepath.Join(t.TempDir(), "init.log")
+	m := NewMetrics()
+	logger2 = nil
+	InitWithRotation("info", file, RotationOptions{}, InitWithMetrics(m))
+	Infoln("hello")
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `zaplog_entries_total{level="info"`) || !strings.Contains(body, `file="`+file+`"`) {
+		t.Fatalf("metrics %s", body)
+	}
+
+	// 不传 InitWithMetrics 时不注册到 DefaultMetrics
+	logger2 = nil
+	other := filepath.Join(t.TempDir(), "plain.log")
+	InitWithRotation("info", other, RotationOptions{})
+	rec = httptest.NewRecorder()
+	DefaultMetrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(rec.Body.String(), other) {
+		t.Fatalf("DefaultMetrics %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,340 @@
+package log
+
+import (
+	"bufio"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"time"
+)
+
+// 只实现 fluent forward 协议需要的 msgpack 子集
+
+func appendMsgpackMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xde, byte(n>>8), byte(n))
+	}
+	return append(b, 0xdf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendMsgpackArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xdc, byte(n>>8), byte(n))
+	}
+	return append(b, 0xdd, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackBin(b []byte, p []byte) []byte {
+	n := len(p)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xc5, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xc6, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, p...)
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendMsgpackUint(b, uint64(i))
+	case i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return append(b, 0xd1, byte(i>>8), byte(i))
+	case i >= math.MinInt32:
+		return append(b, 0xd2, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+	}
+	b = append(b, 0xd3)
+	return appendMsgpackUint64(b, uint64(i))
+}
+
+func appendMsgpackUint(b []byte, u uint64) []byte {
+	switch {
+	case u < 128:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return append(b, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return append(b, 0xcd, byte(u>>8), byte(u))
+	case u <= math.MaxUint32:
+		return append(b, 0xce, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+	}
+	b = append(b, 0xcf)
+	return appendMsgpackUint64(b, u)
+}
+
+// appendMsgpackEventTime fluent EventTime: ext type 0, 秒和纳秒各 4 字节
+func appendMsgpackEventTime(b []byte, t time.Time) []byte {
+	b = append(b, 0xd7, 0x00)
+	b = appendMsgpackUint32(b, uint32(t.Unix()))
+	return appendMsgpackUint32(b, uint32(t.Nanosecond()))
+}
+
+// appendMsgpack 编码 MapObjectEncoder 产生的值, 不认识的类型先转 JSON 再编码
+func appendMsgpack(b []byte, v interface{}) []byte {
+	switch val := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if val {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case string:
+		return appendMsgpackString(b, val)
+	case []byte:
+		return appendMsgpackBin(b, val)
+	case int:
+		return appendMsgpackInt(b, int64(val))
+	case int8:
+		return appendMsgpackInt(b, int64(val))
+	case int16:
+		return appendMsgpackInt(b, int64(val))
+	case int32:
+		return appendMsgpackInt(b, int64(val))
+	case int64:
+		return appendMsgpackInt(b, val)
+	case uint:
+		return appendMsgpackUint(b, uint64(val))
+	case uint8:
+		return appendMsgpackUint(b, uint64(val))
+	case uint16:
+		return appendMsgpackUint(b, uint64(val))
+	case uint32:
+		return appendMsgpackUint(b, uint64(val))
+	case uint64:
+		return appendMsgpackUint(b, val)
+	case uintptr:
+		return appendMsgpackUint(b, uint64(val))
+	case float32:
+		b = append(b, 0xca)
+		return appendMsgpackUint32(b, math.Float32bits(val))
+	case float64:
+		b = append(b, 0xcb)
+		return appendMsgpackUint64(b, math.Float64bits(val))
+	case time.Time:
+		return appendMsgpackString(b, val.Format(time.RFC3339Nano))
+	case time.Duration:
+		return appendMsgpackString(b, val.String())
+	case complex64, complex128:
+		return appendMsgpackString(b, fmt.Sprint(val))
+	case error:
+		return appendMsgpackString(b, val.Error())
+	case fmt.Stringer:
+		return appendMsgpackString(b, val.String())
+	case []interface{}:
+		b = appendMsgpackArrayHeader(b, len(val))
+		for _, e := range val {
+			b = appendMsgpack(b, e)
+		}
+		return b
+	case map[string]interface{}:
+		b = appendMsgpackMapHeader(b, len(val))
+		for k, e := range val {
+			b = appendMsgpackString(b, k)
+			b = appendMsgpack(b, e)
+		}
+		return b
+	}
+	// 反射值 (zap.Any / zap.Reflect), 经 JSON 转成基础类型
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return append(b, 0xc0)
+	}
+	data, err := stdjson.Marshal(v)
+	if err != nil {
+		return appendMsgpackString(b, fmt.Sprint(v))
+	}
+	var generic interface{}
+	if err := stdjson.Unmarshal(data, &generic); err != nil {
+		return appendMsgpackString(b, string(data))
+	}
+	return appendMsgpack(b, generic)
+}
+
+// msgpackExt 解码出的 ext 类型, 如 EventTime
+type msgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+var errMsgpackType = errors.New("log: unsupported msgpack type")
+
+// decodeMsgpack 读取一个 msgpack 值, 用于解析 fluent ack 响应
+func decodeMsgpack(r *bufio.Reader) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return decodeMsgpackMap(r, int(c&0x0f))
+	case c&0xf0 == 0x90:
+		return decodeMsgpackArray(r, int(c&0x0f))
+	case c&0xe0 == 0xa0:
+		return decodeMsgpackString(r, int(c&0x1f))
+	}
+
+	readN := func(n int) ([]byte, error) {
+		p := make([]byte, n)
+		_, err := io.ReadFull(r, p)
+		return p, err
+	}
+	readUint := func(n int) (uint64, error) {
+		p, err := readN(n)
+		if err != nil {
+			return 0, err
+		}
+		var u uint64
+		for _, x := range p {
+			u = u<<8 | uint64(x)
+		}
+		return u, nil
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readUint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return readN(int(n))
+	case 0xca:
+		u, err := readUint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := readUint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := readUint(1 << (c - 0xcc))
+		return int64(u), err
+	case 0xd0:
+		u, err := readUint(1)
+		return int64(int8(u)), err
+	case 0xd1:
+		u, err := readUint(2)
+		return int64(int16(u)), err
+	case 0xd2:
+		u, err := readUint(4)
+		return int64(int32(u)), err
+	case 0xd3:
+		u, err := readUint(8)
+		return int64(u), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		p, err := readN(1 + 1<<(c-0xd4))
+		if err != nil {
+			return nil, err
+		}
+		return msgpackExt{Type: int8(p[0]), Data: p[1:]}, nil
+	case 0xc7, 0xc8, 0xc9:
+		n, err := readUint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		p, err := readN(int(n) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackExt{Type: int8(p[0]), Data: p[1:]}, nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := readUint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackString(r, int(n))
+	case 0xdc, 0xdd:
+		n, err := readUint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackArray(r, int(n))
+	case 0xde, 0xdf:
+		n, err := readUint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackMap(r, int(n))
+	}
+	return nil, fmt.Errorf("%w 0x%02x", errMsgpackType, c)
+}
+
+func decodeMsgpackString(r *bufio.Reader, n int) (string, error) {
+	p := make([]byte, n)
+	_, err := io.ReadFull(r, p)
+	return string(p), err
+}
+
+func decodeMsgpackArray(r *bufio.Reader, n int) ([]interface{}, error) {
+	arr := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+	return arr, nil
+}
+
+func decodeMsgpackMap(r *bufio.Reader, n int) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		m[fmt.Sprint(k)] = v
+	}
+	return m, nil
+}
+
+func appendMsgpackUint32(b []byte, u uint32) []byte {
+	return append(b, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
+
+func appendMsgpackUint64(b []byte, u uint64) []byte {
+	return append(b, byte(u>>56), byte(u>>48), byte(u>>40), byte(u>>32),
+		byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
@@ -0,0 +1,411 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	stdjson "encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	otlpLogsPath = "/v1/logs"
+
+	// DefaultTraceIDKey / DefaultSpanIDKey SetContext 中保存 trace 的 field key
+	DefaultTraceIDKey = "trace_id"
+	DefaultSpanIDKey  = "span_id"
+)
+
+// OTLPConfig NewOTLPCore 的配置, 默认值参考 OTel SDK 的 BatchLogRecordProcessor 和 OTLP exporter
+type OTLPConfig struct {
+	Endpoint string // 如 http://127.0.0.1:4318, 没有 path 时补上 /v1/logs
+	Headers  map[string]string
+	Gzip     bool
+	// Resource resource attributes, 没有 service.name 时使用进程名
+	Resource []zap.Field
+	// TraceIDKey / SpanIDKey 对应的 field 写入 LogRecord 的 traceId / spanId,
+	// 默认 DefaultTraceIDKey / DefaultSpanIDKey, 值需要是 hex 字符串
+	TraceIDKey    string
+	SpanIDKey     string
+	BatchSize     int           // 默认 512
+	FlushInterval time.Duration // 默认 1s
+	QueueSize     int           // 等待发送的满批次数, 超过时丢弃新的批次, 默认 4 (SDK 的 MaxQueueSize 2048 / 512)
+	Timeout       time.Duration // 单次请求超时, 默认 10s
+	// 429/502/503/504 和网络错误按指数退避 (带抖动) 重试, 优先使用 Retry-After (不超过 MaxBackoff)
+	MinBackoff     time.Duration // 默认 5s
+	MaxBackoff     time.Duration // 默认 30s
+	MaxElapsedTime time.Duration // 一个批次的最长重试时间, 默认 1m
+	Client         *http.Client
+}
+
+type otlpCore struct {
+	zapcore.LevelEnabler
+	fields []zapcore.Field
+	client *otlpClient
+}
+
+// NewOTLPCore 以 OTLP/HTTP JSON 发送日志, 返回的 core 实现 io.Closer,
+// Close 发送剩余日志并停止定时发送
+func NewOTLPCore(cfg OTLPConfig, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("log: invalid otlp endpoint %q", cfg.Endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpLogsPath
+	}
+	cfg.Endpoint = u.String()
+	if cfg.TraceIDKey == "" {
+		cfg.TraceIDKey = DefaultTraceIDKey
+	}
+	if cfg.SpanIDKey == "" {
+		cfg.SpanIDKey = DefaultSpanIDKey
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 4
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 5 * time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.MaxElapsedTime <= 0 {
+		cfg.MaxElapsedTime = time.Minute
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+
+	client := &otlpClient{cfg: &cfg}
+	enc := zapcore.NewMapObjectEncoder()
+	for i := range cfg.Resource {
+		cfg.Resource[i].AddTo(enc)
+	}
+	if _, ok := enc.Fields["service.name"]; !ok {
+		enc.Fields["service.name"] = filepath.Base(os.Args[0])
+	}
+	client.resource = otlpAttributes(enc.Fields, nil)
+	client.batch = newBatcher(batcherConfig{
+		MaxItems:  cfg.BatchSize,
+		QueueSize: cfg.QueueSize,
+		Interval:  cfg.FlushInterval,
+	}, client.send)
+	return &otlpCore{LevelEnabler: enab, client: client}, nil
+}
+
+// ContextWithTrace 通过 SetContext 保存 trace id 和 span id. 只有读取 ctx 的调用会带上,
+// 即 ZapLogOper 的 *Ctx 方法和 WithContext 返回的 logger; 普通的 *zap.Logger (如 NewJSONLogger)
+// 不知道 ctx, 需要 logger.With(ContextFields(ctx)...)
+func ContextWithTrace(ctx context.Context, traceID, spanID string) context.Context {
+	return SetContext(ctx, zap.String(DefaultTraceIDKey, traceID), zap.String(DefaultSpanIDKey, spanID))
+}
+
+func (c *otlpCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+	return &clone
+}
+
+func (c *otlpCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *otlpCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	cfg := c.client.cfg
+	enc := zapcore.NewMapObjectEncoder()
+	keys := make([]string, 0, len(c.fields)+len(fields))
+	for _, group := range [][]zapcore.Field{c.fields, fields} {
+		for i := range group {
+			group[i].AddTo(enc)
+			keys = append(keys, group[i].Key)
+		}
+	}
+
+	number, text := OTLPSeverity(ent.Level)
+	rec := otlpRecord{
+		scope:                ent.LoggerName,
+		TimeUnixNano:         strconv.FormatInt(ent.Time.UnixNano(), 10),
+		ObservedTimeUnixNano: strconv.FormatInt(time.Now().UnixNano(), 10),
+		SeverityNumber:       number,
+		SeverityText:         text,
+		Body:                 map[string]interface{}{"stringValue": ent.Message},
+	}
+	if id, ok := enc.Fields[cfg.TraceIDKey].(string); ok && otlpHexID(id, 16) {
+		rec.TraceID = strings.ToLower(id)
+		delete(enc.Fields, cfg.TraceIDKey)
+	}
+	if id, ok := enc.Fields[cfg.SpanIDKey].(string); ok && otlpHexID(id, 8) {
+		rec.SpanID = strings.ToLower(id)
+		delete(enc.Fields, cfg.SpanIDKey)
+	}
+	// 代码位置和堆栈使用 semantic conventions 的名字
+	if ent.Caller.Defined {
+		enc.Fields["code.filepath"] = ent.Caller.File
+		enc.Fields["code.lineno"] = int64(ent.Caller.Line)
+		if ent.Caller.Function != "" {
+			enc.Fields["code.function"] = ent.Caller.Function
+		}
+	}
+	if ent.Stack != "" {
+		enc.Fields["exception.stacktrace"] = ent.Stack
+	}
+	rec.Attributes = otlpAttributes(enc.Fields, keys)
+	return c.client.batch.add(rec, 0)
+}
+
+func (c *otlpCore) Sync() error {
+	return c.client.batch.flush()
+}
+
+func (c *otlpCore) Close() error {
+	return c.client.batch.close()
+}
+
+// OTLPSeverity zap 等级对应的 SeverityNumber 和 SeverityText, 与 otelzap bridge 一致
+func OTLPSeverity(lvl zapcore.Level) (int, string) {
+	switch lvl {
+	case zapcore.DebugLevel:
+		return 5, "DEBUG"
+	case zapcore.InfoLevel:
+		return 9, "INFO"
+	case zapcore.WarnLevel:
+		return 13, "WARN"
+	case zapcore.ErrorLevel:
+		return 17, "ERROR"
+	case zapcore.DPanicLevel:
+		return 18, "DPANIC"
+	case zapcore.PanicLevel:
+		return 19, "PANIC"
+	case zapcore.FatalLevel:
+		return 21, "FATAL"
+	}
+	return 0, lvl.CapitalString()
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpRecord struct {
+	scope                string
+	TimeUnixNano         string                 `json:"timeUnixNano"`
+	ObservedTimeUnixNano string                 `json:"observedTimeUnixNano"`
+	SeverityNumber       int                    `json:"severityNumber"`
+	SeverityText         string                 `json:"severityText"`
+	Body                 map[string]interface{} `json:"body"`
+	Attributes           []otlpKeyValue         `json:"attributes,omitempty"`
+	TraceID              string                 `json:"traceId,omitempty"`
+	SpanID               string                 `json:"spanId,omitempty"`
+}
+
+// otlpAttributes 按 order 的顺序输出, 其余 key 排序后追加
+func otlpAttributes(fields map[string]interface{}, order []string) []otlpKeyValue {
+	attrs := make([]otlpKeyValue, 0, len(fields))
+	seen := make(map[string]struct{}, len(fields))
+	for _, k := range order {
+		if v, ok := fields[k]; ok {
+			if _, dup := seen[k]; !dup {
+				seen[k] = struct{}{}
+				attrs = append(attrs, otlpKeyValue{Key: k, Value: otlpValue(v)})
+			}
+		}
+	}
+	rest := make([]string, 0, len(fields)-len(seen))
+	for k := range fields {
+		if _, ok := seen[k]; !ok {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	for _, k := range rest {
+		attrs = append(attrs, otlpKeyValue{Key: k, Value: otlpValue(fields[k])})
+	}
+	return attrs
+}
+
+// otlpValue AnyValue 的 JSON 形式, int64 按 proto3 JSON 规则编码为字符串
+func otlpValue(v interface{}) map[string]interface{} {
+	switch val := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": val}
+	case bool:
+		return map[string]interface{}{"boolValue": val}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+	case uint64:
+		if val <= math.MaxInt64 {
+			return map[string]interface{}{"intValue": strconv.FormatUint(val, 10)}
+		}
+		return map[string]interface{}{"stringValue": strconv.FormatUint(val, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": val}
+	case []byte:
+		return map[string]interface{}{"bytesValue": base64.StdEncoding.EncodeToString(val)}
+	case []interface{}:
+		values := make([]map[string]interface{}, 0, len(val))
+		for _, e := range val {
+			values = append(values, otlpValue(e))
+		}
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	case map[string]interface{}:
+		return map[string]interface{}{"kvlistValue": map[string]interface{}{"values": otlpAttributes(val, nil)}}
+	}
+	return map[string]interface{}{"stringValue": journalValue(v)}
+}
+
+func otlpHexID(id string, size int) bool {
+	if len(id) != size*2 {
+		return false
+	}
+	b, err := hex.DecodeString(id)
+	if err != nil {
+		return false
+	}
+	for _, c := range b {
+		if c != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type otlpClient struct {
+	cfg      *OTLPConfig
+	resource []otlpKeyValue
+	batch    *batcher
+}
+
+func (cl *otlpClient) send(ctx context.Context, items []interface{}) error {
+	records := make([]otlpRecord, len(items))
+	for i, item := range items {
+		records[i] = item.(otlpRecord)
+	}
+	body, err := cl.encode(records)
+	if err != nil {
+		return err
+	}
+	return cl.export(ctx, body)
+}
+
+// encode ExportLogsServiceRequest, 同一个 logger name 作为一个 instrumentation scope
+func (cl *otlpClient) encode(records []otlpRecord) ([]byte, error) {
+	type scopeLogs struct {
+		Scope      map[string]string `json:"scope"`
+		LogRecords []otlpRecord      `json:"logRecords"`
+	}
+	var scopes []*scopeLogs
+	index := make(map[string]*scopeLogs)
+	for _, rec := range records {
+		s, ok := index[rec.scope]
+		if !ok {
+			s = &scopeLogs{Scope: map[string]string{"name": rec.scope}}
+			index[rec.scope] = s
+			scopes = append(scopes, s)
+		}
+		s.LogRecords = append(s.LogRecords, rec)
+	}
+	req := map[string]interface{}{
+		"resourceLogs": []map[string]interface{}{{
+			"resource":  map[string]interface{}{"attributes": cl.resource},
+			"scopeLogs": scopes,
+		}},
+	}
+	data, err := stdjson.Marshal(req)
+	if err != nil || !cl.cfg.Gzip {
+		return data, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// export 按 OTLP/HTTP 的约定重试: 429/502/503/504 和网络错误可重试, 其他状态码直接失败
+func (cl *otlpClient) export(ctx context.Context, body []byte) error {
+	policy := retryPolicy{
+		minBackoff: cl.cfg.MinBackoff,
+		maxBackoff: cl.cfg.MaxBackoff,
+		maxElapsed: cl.cfg.MaxElapsedTime,
+		jitter:     true,
+	}
+	return policy.do(ctx, func() (bool, time.Duration, error) {
+		return cl.exportOnce(ctx, body)
+	})
+}
+
+func (cl *otlpClient) exportOnce(ctx context.Context, body []byte) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, cl.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cl.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cl.cfg.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range cl.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := cl.cfg.Client.Do(req)
+	if err != nil {
+		return true, 0, fmt.Errorf("log: otlp export: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var result struct {
+			PartialSuccess struct {
+				RejectedLogRecords stdjson.Number `json:"rejectedLogRecords"`
+				ErrorMessage       string         `json:"errorMessage"`
+			} `json:"partialSuccess"`
+		}
+		if stdjson.Unmarshal(data, &result) == nil {
+			if n := result.PartialSuccess.RejectedLogRecords; n != "" && n != "0" {
+				return false, 0, fmt.Errorf("log: otlp export: %s log records rejected: %s", n, result.PartialSuccess.ErrorMessage)
+			}
+		}
+		return false, 0, nil
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, retryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("log: otlp export: status %d", resp.StatusCode)
+	}
+	return false, 0, fmt.Errorf("log: otlp export: status %d: %s", resp.StatusCode, bytes.TrimSpace(data))
+}
@@ -0,0 +1,181 @@
+package log
+
+import (
+	"compress/gzip"
+	"context"
+	stdjson "encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type otlpLogsRequest struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []struct {
+			Scope      map[string]string `json:"scope"`
+			LogRecords []struct {
+				SeverityNumber int                    `json:"severityNumber"`
+				SeverityText   string                 `json:"severityText"`
+				Body           map[string]interface{} `json:"body"`
+				Attributes     []otlpKeyValue         `json:"attributes"`
+				TraceID        string                 `json:"traceId"`
+				SpanID         string                 `json:"spanId"`
+			} `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+// otlpCollector 前 unavailable 次请求返回 503
+type otlpCollector struct {
+	mu          sync.Mutex
+	unavailable int
+	attempts    int
+	reqs        []otlpLogsRequest
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts++
+	if r.URL.Path != otlpLogsPath || r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if c.unavailable > 0 {
+		c.unavailable--
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	var req otlpLogsRequest
+	if err := stdjson.NewDecoder(body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.reqs = append(c.reqs, req)
+	w.Write([]byte(`{}`))
+}
+
+func otlpAttr(attrs []otlpKeyValue, key string) map[string]interface{} {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return nil
+}
+
+func TestOTLPTraceContext(t *testing.T) {
+	collector := &otlpCollector{unavailable: 1}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	core, err := NewOTLPCore(OTLPConfig{
+		Endpoint:      srv.URL,
+		Gzip:          true,
+		Resource:      []zap.Field{zap.String("service.name", "demo")},
+		FlushInterval: time.Hour,
+		MinBackoff:    time.Millisecond,
+	}, zap.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.(io.Closer).Close()
+	l := NewZapLoggerWithOptions(nil, WithExtraCores(core), WithFallbackWriters(&syncBuffer{}))
+
+	ctx := ContextWithTrace(context.Background(), "4BF92F3577B34DA6A3CE929D0E0E4736", "00f067aa0ba902b7")
+	l.Named("api").WarnCtx(ctx, "slow", zap.Int("n", 3), zap.Strings("tags", []string{"a", "b"}))
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if collector.attempts != 2 || len(collector.reqs) != 1 {
+		t.Fatalf("attempts %d, requests %d", collector.attempts, len(collector.reqs))
+	}
+	rl := collector.reqs[0].ResourceLogs[0]
+	if v := otlpAttr(rl.Resource.Attributes, "service.name"); v["stringValue"] != "demo" {
+		t.Fatalf("resource %v", rl.Resource.Attributes)
+	}
+	scope := rl.ScopeLogs[0]
+	rec := scope.LogRecords[0]
+	if scope.Scope["name"] != "api" || rec.SeverityNumber != 13 || rec.SeverityText != "WARN" || rec.Body["stringValue"] != "slow" {
+		t.Fatalf("record %+v in scope %v", rec, scope.Scope)
+	}
+	if rec.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || rec.SpanID != "00f067aa0ba902b7" {
+		t.Fatalf("trace %q span %q", rec.TraceID, rec.SpanID)
+	}
+	if otlpAttr(rec.Attributes, DefaultTraceIDKey) != nil {
+		t.Fatalf("trace id left in attributes %v", rec.Attributes)
+	}
+	if v := otlpAttr(rec.Attributes, "n"); v["intValue"] != "3" {
+		t.Fatalf("n = %v", v)
+	}
+	if v := otlpAttr(rec.Attributes, "tags"); v["arrayValue"] == nil {
+		t.Fatalf("tags = %v", v)
+	}
+}
+
+func TestOTLPResourceFromStaticFields(t *testing.T) {
+	collector := &otlpCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	logger, err := NewJSONLogger(
+		WithDisableConsole(),
+		WithField("env", "prod"),
+		WithOTLP(OTLPConfig{Endpoint: srv.URL, FlushInterval: time.Hour}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Error("boom", zap.String("k", "v"))
+	// 子 logger 的同名字段仍是 record attribute, 普通 logger 用 ContextFields 带上 trace
+	ctx := ContextWithTrace(context.Background(), "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7")
+	logger.With(zap.String("env", "canary")).With(ContextFields(ctx)...).Info("child")
+	if err := logger.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if len(collector.reqs) != 1 {
+		t.Fatalf("requests %d", len(collector.reqs))
+	}
+	rl := collector.reqs[0].ResourceLogs[0]
+	if v := otlpAttr(rl.Resource.Attributes, "env"); v["stringValue"] != "prod" {
+		t.Fatalf("resource %v", rl.Resource.Attributes)
+	}
+	if otlpAttr(rl.Resource.Attributes, "service.name") == nil {
+		t.Fatalf("resource %v", rl.Resource.Attributes)
+	}
+	rec := rl.ScopeLogs[0].LogRecords[0]
+	if otlpAttr(rec.Attributes, "env") != nil || otlpAttr(rec.Attributes, "k") == nil || otlpAttr(rec.Attributes, "code.lineno") == nil {
+		t.Fatalf("attributes %v", rec.Attributes)
+	}
+	if rec.SeverityNumber != 17 || rec.TraceID != "" {
+		t.Fatalf("record %+v", rec)
+	}
+	child := rl.ScopeLogs[0].LogRecords[1]
+	if v := otlpAttr(child.Attributes, "env"); v["stringValue"] != "canary" || child.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("child record %+v", child)
+	}
+}
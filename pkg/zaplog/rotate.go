@@ -0,0 +1,375 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultBackupTimeFormat 备份文件名中的时间格式, 与 lumberjack 相同
+	DefaultBackupTimeFormat = "2006-01-02T15-04-05.000"
+
+	defaultRotateMaxSize = 100
+	megabyte             = 1024 * 1024
+	compressSuffix       = ".gz"
+)
+
+// RotationOptions 日志文件切割配置, WithFileRotationP / NewLogger / InitWithConfig 共用
+type RotationOptions struct {
+	MaxSize    int  // 单个文件最大尺寸, 单位 M, 0 为 100
+	MaxBackups int  // 最多保留的备份数, 0 不限制
+	MaxAge     int  // 备份最多保留天数, 0 不限制
+	Compress   bool // 备份是否 gzip 压缩
+	LocalTime  bool // 备份文件名使用本地时间, 否则 UTC
+
+	// BackupTimeFormat 备份文件名 name-<time>.ext 中的时间格式, 默认 DefaultBackupTimeFormat
+	BackupTimeFormat string
+
+	FileMode os.FileMode // 新建日志文件的权限, 默认 DefaultFileMode, 已有文件切割后沿用原权限
+	DirMode  os.FileMode // 新建目录的权限, 默认 DefaultDirMode
+
+	// OnRotate 备份完成(含压缩)后调用, 参数为备份文件路径, 在后台 goroutine 执行
+	OnRotate func(backup string)
+	// OnDelete 超过 MaxBackups/MaxAge 的备份删除后调用
+	OnDelete func(backup string)
+}
+
+// RotateWriter 按大小切割的文件 writer, 并发安全.
+// 切割规则和备份文件名默认与 lumberjack 相同, 另外支持自定义备份时间格式和 OnRotate/OnDelete 回调,
+// lumberjack 不提供这两项 (备份名固定, 切割和清理在内部完成, 没有通知)
+type RotateWriter struct {
+	filename string
+	opts     RotationOptions
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	millCh   chan struct{} // 通知后台有新的备份, 容量 1, 第一次切割时创建, Close 时关闭
+	millDone chan struct{}
+	millMu   sync.Mutex
+	pending  []string // 等待压缩和回调的备份
+
+	rotations uint64
+}
+
+var _ io.WriteCloser = (*RotateWriter)(nil)
+
+// NewRotateWriter 文件在第一次写入时打开
+func NewRotateWriter(filename string, opts RotationOptions) *RotateWriter {
+	if opts.BackupTimeFormat == "" {
+		opts.BackupTimeFormat = DefaultBackupTimeFormat
+	}
+	if opts.FileMode == 0 {
+		opts.FileMode = DefaultFileMode
+	}
+	if opts.DirMode == 0 {
+		opts.DirMode = DefaultDirMode
+	}
+	return &RotateWriter{filename: filename, opts: opts}
+}
+
+func (w *RotateWriter) maxBytes() int64 {
+	if w.opts.MaxSize <= 0 {
+		return defaultRotateMaxSize * megabyte
+	}
+	return int64(w.opts.MaxSize) * megabyte
+}
+
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.openExisting(); err != nil {
+			return 0, err
+		}
+	}
+	// 单条超过上限时也写进新文件, 不截断
+	if w.size > 0 && w.size+int64(len(p)) > w.maxBytes() {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *RotateWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// Close 关闭当前文件, 并等后台处理完已切割的备份后退出
+func (w *RotateWriter) Close() error {
+	w.mu.Lock()
+	err := w.close()
+	millCh, millDone := w.millCh, w.millDone
+	w.millCh, w.millDone = nil, nil
+	w.mu.Unlock()
+	// OnRotate 可能写日志, 不持有 mu 等待
+	if millCh != nil {
+		close(millCh)
+		<-millDone
+	}
+	return err
+}
+
+// Rotate 立即切割当前文件
+func (w *RotateWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		if err := w.openExisting(); err != nil {
+			return err
+		}
+	}
+	return w.rotate()
+}
+
+// Rotations 切割次数, 包括手动 Rotate
+func (w *RotateWriter) Rotations() uint64 {
+	return atomic.LoadUint64(&w.rotations)
+}
+
+func (w *RotateWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	w.size = 0
+	return err
+}
+
+func (w *RotateWriter) openExisting() error {
+	if err := os.MkdirAll(filepath.Dir(w.filename), w.opts.DirMode); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, w.opts.FileMode)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+// rotate 改名当前文件为备份并打开新文件, 新文件沿用原文件的权限
+func (w *RotateWriter) rotate() error {
+	mode := w.opts.FileMode
+	if info, err := w.file.Stat(); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := w.close(); err != nil {
+		return err
+	}
+	backup := w.backupName(w.now())
+	if err := os.Rename(w.filename, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(w.filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.size = 0
+	atomic.AddUint64(&w.rotations, 1)
+
+	if w.millCh == nil {
+		w.millCh, w.millDone = make(chan struct{}, 1), make(chan struct{})
+		go w.millRun(w.millCh, w.millDone)
+	}
+	// 持有 mu, 不能阻塞: 备份放进 pending, 通知已经在 channel 里时不用再发
+	w.millMu.Lock()
+	w.pending = append(w.pending, backup)
+	w.millMu.Unlock()
+	select {
+	case w.millCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (w *RotateWriter) now() time.Time {
+	if w.opts.LocalTime {
+		return time.Now()
+	}
+	return time.Now().UTC()
+}
+
+func (w *RotateWriter) prefixAndExt() (string, string) {
+	base := filepath.Base(w.filename)
+	ext := filepath.Ext(base)
+	return base[:len(base)-len(ext)] + "-", ext
+}
+
+// backupName dir/name-<time>.ext, 重名时在时间后加 -N
+func (w *RotateWriter) backupName(t time.Time) string {
+	prefix, ext := w.prefixAndExt()
+	stamp := t.Format(w.opts.BackupTimeFormat)
+	name := filepath.Join(filepath.Dir(w.filename), prefix+stamp+ext)
+	for i := 1; fileExists(name) || fileExists(name+compressSuffix); i++ {
+		name = filepath.Join(filepath.Dir(w.filename), prefix+stamp+"-"+strconv.Itoa(i)+ext)
+	}
+	return name
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// 后台压缩和清理, 切割在写路径上只做 rename;
+// 清理前先处理完队列里的备份, 避免删掉还没压缩的文件
+func (w *RotateWriter) millRun(millCh, done chan struct{}) {
+	defer close(done)
+	for range millCh {
+		w.millMu.Lock()
+		pending := w.pending
+		w.pending = nil
+		w.millMu.Unlock()
+		for _, backup := range pending {
+			w.millOne(backup)
+		}
+		w.removeOld()
+	}
+}
+
+func (w *RotateWriter) millOne(backup string) {
+	if w.opts.Compress {
+		if err := compressFile(backup, backup+compressSuffix); err != nil {
+			fmt.Fprintf(os.Stderr, "log: compress %s: %v\n", backup, err)
+		} else {
+			backup += compressSuffix
+		}
+	}
+	if w.opts.OnRotate != nil {
+		w.opts.OnRotate(backup)
+	}
+}
+
+type backupFile struct {
+	path string
+	t    time.Time
+	n    int // 重名序号
+}
+
+// backups 按时间倒序返回当前文件的备份
+func (w *RotateWriter) backups() ([]backupFile, error) {
+	dir := filepath.Dir(w.filename)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	loc := time.UTC
+	if w.opts.LocalTime {
+		loc = time.Local
+	}
+	prefix, ext := w.prefixAndExt()
+	var files []backupFile
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		stamp := strings.TrimSuffix(e.Name(), compressSuffix)
+		if !strings.HasPrefix(stamp, prefix) || !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		stamp = stamp[len(prefix) : len(stamp)-len(ext)]
+		n := 0
+		t, err := time.ParseInLocation(w.opts.BackupTimeFormat, stamp, loc)
+		if err != nil {
+			// 重名时的 -N 后缀
+			i := strings.LastIndex(stamp, "-")
+			if i < 0 {
+				continue
+			}
+			if n, err = strconv.Atoi(stamp[i+1:]); err != nil {
+				continue
+			}
+			if t, err = time.ParseInLocation(w.opts.BackupTimeFormat, stamp[:i], loc); err != nil {
+				continue
+			}
+		}
+		files = append(files, backupFile{path: filepath.Join(dir, e.Name()), t: t, n: n})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].t.Equal(files[j].t) {
+			return files[i].n > files[j].n
+		}
+		return files[i].t.After(files[j].t)
+	})
+	return files, nil
+}
+
+func (w *RotateWriter) removeOld() {
+	if w.opts.MaxBackups <= 0 && w.opts.MaxAge <= 0 {
+		return
+	}
+	files, err := w.backups()
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-time.Duration(w.opts.MaxAge) * 24 * time.Hour)
+	for i, f := range files {
+		tooMany := w.opts.MaxBackups > 0 && i >= w.opts.MaxBackups
+		tooOld := w.opts.MaxAge > 0 && f.t.Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			continue
+		}
+		if w.opts.OnDelete != nil {
+			w.opts.OnDelete(f.path)
+		}
+	}
+}
+
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
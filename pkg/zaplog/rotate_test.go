@@ -0,0 +1,180 @@
+package log
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRotateWriter(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		mu               sync.Mutex
+		rotated, deleted []string
+	)
+	w := NewRotateWriter(filepath.Join(dir, "app.log"), RotationOptions{
+		MaxSize:          1,
+		MaxBackups:       1,
+		Compress:         true,
+		BackupTimeFormat: "20060102T150405",
+		OnRotate: func(backup string) {
+			mu.Lock()
+			rotated = append(rotated, backup)
+			mu.Unlock()
+		},
+		OnDelete: func(backup string) {
+			mu.Lock()
+			deleted = append(deleted, backup)
+			mu.Unlock()
+		},
+	})
+	defer w.Close()
+
+	chunk := bytes.Repeat([]byte("x"), 600*1024)
+	for i := 0; i < 4; i++ {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 4 次写入切割 3 次, 只保留 1 个备份
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		done := len(rotated) == 3 && len(deleted) == 2
+		mu.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(rotated) != 3 || len(deleted) != 2 {
+		t.Fatalf("rotated=%v deleted=%v", rotated, deleted)
+	}
+	for _, name := range rotated {
+		if !strings.HasPrefix(filepath.Base(name), "app-") || !strings.HasSuffix(name, ".log.gz") {
+			t.Errorf("unexpected backup name %s", name)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "app-*"))
+	if len(files) != 1 || files[0] != rotated[2] {
+		t.Fatalf("backups on disk = %v, want [%s]", files, rotated[2])
+	}
+	info, err := os.Stat(filepath.Join(dir, "app.log"))
+	if err != nil || info.Size() != int64(len(chunk)) {
+		t.Fatalf("current file: %v %v", info, err)
+	}
+}
+
+func TestRotateWriterSlowCallback(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	w := NewRotateWriter(filepath.Join(t.TempDir(), "app.log"), RotationOptions{
+		OnRotate: func(string) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+		},
+	})
+	defer w.Close()
+
+	// 回调阻塞时切割不能卡住写入
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 50; i++ {
+			if _, err := w.Write([]byte("line\n")); err != nil {
+				done <- err
+				return
+			}
+			if err := w.Rotate(); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Rotate blocked on the mill goroutine")
+	}
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&calls) != 50 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&calls); n != 50 {
+		t.Fatalf("OnRotate called %d times", n)
+	}
+}
+
+func TestRotateWriterCloseStopsMill(t *testing.T) {
+	var calls int32
+	w := NewRotateWriter(filepath.Join(t.TempDir(), "app.log"), RotationOptions{
+		Compress: true,
+		OnRotate: func(string) {
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&calls, 1)
+		},
+	})
+	for i := 0; i < 3; i++ {
+		if _, err := w.Write([]byte("line\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Close 返回时已切割的备份都处理完, 后台 goroutine 已退出
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("OnRotate called %d times before Close returned", n)
+	}
+	if w.millCh != nil {
+		t.Fatal("mill still running")
+	}
+
+	// Close 之后再写会重新打开文件, 切割时重新启动后台 goroutine
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 4 {
+		t.Fatalf("OnRotate called %d times", n)
+	}
+}
+
+func TestRotateWriterModes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	w := NewRotateWriter(filepath.Join(dir, "app.log"), RotationOptions{FileMode: 0600, DirMode: 0700})
+	defer w.Close()
+	if _, err := w.Write([]byte("line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]os.FileMode{dir: 0700, filepath.Join(dir, "app.log"): 0600} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Fatalf("%s mode = %v, want %v", name, info.Mode().Perm(), want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package log
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RegisterSinks 注册到 zap 的输出 scheme, 之后 zap.Config.OutputPaths / zap.Open 可以直接使用:
+//
+//	rotate:///var/log/app.log?maxsize=100&maxage=7&maxbackups=3&compress=true&localtime=true
+//	tcp://127.0.0.1:5170?timeout=1s
+//	udp://127.0.0.1:5170
+//	unix:///var/run/log.sock
+const (
+	SchemeRotate = "rotate"
+	SchemeTCP    = "tcp"
+	SchemeUDP    = "udp"
+	SchemeUnix   = "unix"
+)
+
+const defaultSinkTimeout = 3 * time.Second
+
+var registerSinks struct {
+	once sync.Once
+	err  error
+}
+
+// RegisterSinks 把 rotate/tcp/udp/unix 注册到 zap 的全局 scheme, 只在第一次调用时注册.
+// scheme 已被其他包注册时返回错误, 不覆盖原来的实现. OpenSinks, SetOutputPaths 和
+// WithOutputPaths 会先调用它, 直接用 zap.Open 或 zap.Config 时需要自己调用
+func RegisterSinks() error {
+	registerSinks.once.Do(func() {
+		err := zap.RegisterSink(SchemeRotate, newRotateSink)
+		for _, scheme := range []string{SchemeTCP, SchemeUDP, SchemeUnix} {
+			err = multierr.Append(err, zap.RegisterSink(scheme, newNetSink))
+		}
+		if err != nil {
+			registerSinks.err = fmt.Errorf("log: register sinks: %w", err)
+		}
+	})
+	return registerSinks.err
+}
+
+// OpenSinks 同 zap.Open, 支持本包的 scheme, 结果可用于 NewZapLogger 的 writers
+func OpenSinks(paths ...string) (zapcore.WriteSyncer, func(), error) {
+	if err := RegisterSinks(); err != nil {
+		return nil, nil, err
+	}
+	return zap.Open(paths...)
+}
+
+func newRotateSink(u *url.URL) (zap.Sink, error) {
+	path := u.Path
+	if u.Opaque != "" {
+		path = u.Opaque
+	} else if u.Host != "" {
+		path = u.Host + u.Path
+	}
+	if path == "" {
+		return nil, fmt.Errorf("log: rotate sink %q has no file path", u.String())
+	}
+
+	rotation := RotationOptions{}
+	q := u.Query()
+	var err error
+	intParam := func(name string, dst *int) {
+		if v := q.Get(name); v != "" && err == nil {
+			if *dst, err = strconv.Atoi(v); err != nil {
+				err = fmt.Errorf("log: rotate sink %s=%q: %v", name, v, err)
+			}
+		}
+	}
+	boolParam := func(name string, dst *bool) {
+		if v := q.Get(name); v != "" && err == nil {
+			if *dst, err = strconv.ParseBool(v); err != nil {
+				err = fmt.Errorf("log: rotate sink %s=%q: %v", name, v, err)
+			}
+		}
+	}
+	intParam("maxsize", &rotation.MaxSize)
+	intParam("maxage", &rotation.MaxAge)
+	intParam("maxbackups", &rotation.MaxBackups)
+	boolParam("compress", &rotation.Compress)
+	boolParam("localtime", &rotation.LocalTime)
+	rotation.BackupTimeFormat = q.Get("timeformat")
+	if err != nil {
+		return nil, err
+	}
+	return NewRotateWriter(path, rotation), nil
+}
+
+// netSink 每次 Write 发送一条日志, 写失败时断开并重连一次, 重连后只发送没写完的部分
+type netSink struct {
+	network string
+	addr    string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newNetSink(u *url.URL) (zap.Sink, error) {
+	s := &netSink{network: u.Scheme, addr: u.Host, timeout: defaultSinkTimeout}
+	if u.Scheme == SchemeUnix {
+		s.addr = u.Path
+	}
+	if s.addr == "" {
+		return nil, fmt.Errorf("log: %s sink %q has no address", u.Scheme, u.String())
+	}
+	if v := u.Query().Get("timeout"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("log: %s sink timeout=%q: %v", u.Scheme, v, err)
+		}
+		s.timeout = timeout
+	}
+	return s, nil
+}
+
+func (s *netSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		written int
+		err     error
+	)
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if s.conn, err = net.DialTimeout(s.network, s.addr, s.timeout); err != nil {
+				s.conn = nil
+				return written, err
+			}
+		}
+		if s.timeout > 0 {
+			_ = s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+		}
+		var n int
+		n, err = s.conn.Write(p[written:])
+		if written += n; err == nil {
+			return written, nil
+		}
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	return written, err
+}
+
+func (s *netSink) Sync() error {
+	return nil
+}
+
+func (s *netSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
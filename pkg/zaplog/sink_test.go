@@ -0,0 +1,159 @@
+package log
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		lines <- line
+	}()
+
+	logger, err := NewJSONLogger(WithDisableConsole(), WithOutputPaths("tcp://"+ln.Addr().String()+"?timeout=1s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("over tcp")
+	select {
+	case line := <-lines:
+		if !strings.Contains(line, `"msg":"over tcp"`) {
+			t.Fatalf("received %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no line received")
+	}
+}
+
+func TestRotateSink(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	ws, closeFn, err := OpenSinks("rotate://" + file + "?maxsize=1&maxbackups=2&compress=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFn()
+	if _, err := ws.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil || string(data) != "hello\n" {
+		t.Fatalf("file = %q, %v", data, err)
+	}
+
+	if _, _, err := OpenSinks("rotate://" + file + "?maxsize=big"); err == nil {
+		t.Fatal("expected error for bad maxsize")
+	}
+}
+
+func TestBuildLoggerBadOutputPath(t *testing.T) {
+	before, _ := GetAtomicLevel()
+	logger, err := BuildLogger(SetLogFileDir(t.TempDir()), SetOutputPaths("rotate:///"+filepath.Join(t.TempDir(), "app.log")+"?maxsize=abc"))
+	if err == nil || logger != nil {
+		t.Fatalf("logger %v, err %v", logger, err)
+	}
+	// 失败时不替换已有的 logger
+	if after, ok := GetAtomicLevel(); !ok || after != before {
+		t.Fatalf("zaplog1 level replaced by failed build")
+	}
+}
+
+// halfConn 只写出前 n 个字节就报错
+type halfConn struct {
+	net.Conn
+	n int
+}
+
+func (c halfConn) Write(p []byte) (int, error)        { return c.n, errors.New("broken pipe") }
+func (c halfConn) SetWriteDeadline(t time.Time) error { return nil }
+func (c halfConn) Close() error                       { return nil }
+
+func TestNetSinkPartialWrite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		data, _ := io.ReadAll(conn)
+		conn.Close()
+		received <- string(data)
+	}()
+
+	s := &netSink{network: "tcp", addr: ln.Addr().String(), timeout: time.Second, conn: halfConn{n: 4}}
+	// 重连后只发送旧连接上没写完的部分
+	if n, err := s.Write([]byte("over tcp\n")); err != nil || n != 9 {
+		t.Fatalf("n %d, err %v", n, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		if data != " tcp\n" {
+			t.Fatalf("received %q", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing received")
+	}
+}
+
+func TestOpenJSONLoggerClose(t *testing.T) {
+	if err := RegisterSinks(); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		data, _ := io.ReadAll(conn)
+		conn.Close()
+		received <- string(data)
+	}()
+
+	logger, closeAll, err := OpenJSONLogger(WithDisableConsole(), WithOutputPaths("tcp://"+ln.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("before close")
+	// closeAll 关闭连接, 对端读到 EOF
+	closeAll()
+	select {
+	case data := <-received:
+		if !strings.Contains(data, `"msg":"before close"`) {
+			t.Fatalf("received %q", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not closed")
+	}
+}
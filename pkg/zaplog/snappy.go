@@ -0,0 +1,75 @@
+package log
+
+import "encoding/binary"
+
+// snappyEncode snappy block 格式 (非 framing 格式), Loki protobuf push 使用.
+// 只做简单的 4 字节哈希匹配, 压缩率不如官方实现但格式兼容
+func snappyEncode(src []byte) []byte {
+	dst := make([]byte, binary.MaxVarintLen64, len(src)/2+binary.MaxVarintLen64+16)
+	dst = dst[:binary.PutUvarint(dst, uint64(len(src)))]
+	if len(src) < 8 {
+		return snappyLiteral(dst, src)
+	}
+
+	const tableBits = 14
+	var table [1 << tableBits]int32 // 位置 + 1, 0 表示空
+	load32 := func(i int) uint32 {
+		return binary.LittleEndian.Uint32(src[i:])
+	}
+	hash := func(u uint32) uint32 {
+		return (u * 0x1e35a7bd) >> (32 - tableBits)
+	}
+
+	lit := 0
+	for i := 0; i+4 <= len(src); {
+		cur := load32(i)
+		h := hash(cur)
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || i-cand > 0xffff || load32(cand) != cur {
+			i++
+			continue
+		}
+		dst = snappyLiteral(dst, src[lit:i])
+		n := 4
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+		dst = snappyCopy(dst, i-cand, n)
+		i += n
+		lit = i
+	}
+	return snappyLiteral(dst, src[lit:])
+}
+
+func snappyLiteral(dst, lit []byte) []byte {
+	n := len(lit) - 1
+	switch {
+	case n < 0:
+		return dst
+	case n < 60:
+		dst = append(dst, byte(n)<<2)
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// snappyCopy 使用 2 字节 offset 的 copy, 每段最长 64
+func snappyCopy(dst []byte, offset, length int) []byte {
+	for length > 0 {
+		n := length
+		if n > 64 {
+			n = 64
+		}
+		dst = append(dst, byte(n-1)<<2|2, byte(offset), byte(offset>>8))
+		length -= n
+	}
+	return dst
+}
@@ -0,0 +1,215 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// SyslogFormat syslog 报文格式
+type SyslogFormat int
+
+const (
+	// RFC5424 <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+	RFC5424 SyslogFormat = iota
+	// RFC3164 <PRI>Mmm dd hh:mm:ss HOSTNAME APP-NAME[PID]: MSG
+	RFC3164
+)
+
+// syslog facility
+const (
+	FacilityUser   = 1
+	FacilityDaemon = 3
+	FacilityLocal0 = 16
+	FacilityLocal1 = 17
+	FacilityLocal2 = 18
+	FacilityLocal3 = 19
+	FacilityLocal4 = 20
+	FacilityLocal5 = 21
+	FacilityLocal6 = 22
+	FacilityLocal7 = 23
+)
+
+// SyslogConfig NewSyslogCore 的配置
+type SyslogConfig struct {
+	Network  string       // udp / tcp / unixgram, tcp 使用 octet-counting 分帧
+	Addr     string       // host:port 或 unix socket 路径
+	Format   SyslogFormat // 默认 RFC5424
+	Facility int          // 0 为 FacilityUser
+	AppName  string       // 默认进程名
+	Hostname string       // 默认 os.Hostname()
+	Timeout  time.Duration
+
+	// Encoder MSG 部分的编码器, 默认不带 time/level 的 JSON, 这两项已在报文头里
+	Encoder zapcore.Encoder
+}
+
+// SyslogSeverity zap 等级对应的 syslog severity
+func SyslogSeverity(lvl zapcore.Level) int {
+	switch lvl {
+	case zapcore.DebugLevel:
+		return 7 // debug
+	case zapcore.InfoLevel:
+		return 6 // info
+	case zapcore.WarnLevel:
+		return 4 // warning
+	case zapcore.ErrorLevel:
+		return 3 // err
+	case zapcore.DPanicLevel:
+		return 2 // crit
+	case zapcore.PanicLevel:
+		return 1 // alert
+	case zapcore.FatalLevel:
+		return 0 // emerg
+	}
+	return 5 // notice
+}
+
+var (
+	_syslogPool = buffer.NewPool()
+
+	_ zapcore.Core = (*syslogCore)(nil)
+)
+
+type syslogCore struct {
+	zapcore.LevelEnabler
+	enc      zapcore.Encoder
+	out      *netSink
+	format   SyslogFormat
+	facility int
+	appName  string
+	hostname string
+	pid      string
+	stream   bool
+}
+
+// NewSyslogCore 按 RFC5424/RFC3164 发送到 syslog, 连接在第一次写入时建立, 断开后自动重连
+func NewSyslogCore(cfg SyslogConfig, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	switch cfg.Network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "unixgram":
+	case "unix":
+		cfg.Network = "unixgram"
+	default:
+		return nil, fmt.Errorf("log: unsupported syslog network %q", cfg.Network)
+	}
+	if cfg.Facility == 0 {
+		cfg.Facility = FacilityUser
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = filepath.Base(os.Args[0])
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultSinkTimeout
+	}
+	if cfg.Encoder == nil {
+		cfg.Encoder = zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+			MessageKey:     "msg",
+			NameKey:        "logger",
+			CallerKey:      "caller",
+			StacktraceKey:  "stacktrace",
+			EncodeDuration: zapcore.StringDurationEncoder,
+			EncodeCaller:   zapcore.ShortCallerEncoder,
+		})
+	}
+	return &syslogCore{
+		LevelEnabler: enab,
+		enc:          cfg.Encoder,
+		out:          &netSink{network: cfg.Network, addr: cfg.Addr, timeout: cfg.Timeout},
+		format:       cfg.Format,
+		facility:     cfg.Facility,
+		appName:      syslogToken(cfg.AppName),
+		hostname:     syslogToken(cfg.Hostname),
+		pid:          strconv.Itoa(os.Getpid()),
+		stream:       cfg.Network[:3] == "tcp",
+	}, nil
+}
+
+// RFC5424 header 字段不能为空或含空格
+func syslogToken(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(s, " ", "_")
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for i := range fields {
+		fields[i].AddTo(clone.enc)
+	}
+	return &clone
+}
+
+func (c *syslogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *syslogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	body, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	defer body.Free()
+	msg := bytes.TrimRight(body.Bytes(), "\r\n")
+
+	buf := c.header(ent)
+	defer buf.Free()
+	buf.Write(msg)
+
+	packet := buf.Bytes()
+	if c.stream {
+		// RFC 6587 octet-counting: "LEN SP MSG"
+		framed := make([]byte, 0, len(packet)+8)
+		framed = strconv.AppendInt(framed, int64(len(packet)), 10)
+		framed = append(framed, ' ')
+		packet = append(framed, packet...)
+	}
+	_, err = c.out.Write(packet)
+	return err
+}
+
+func (c *syslogCore) header(ent zapcore.Entry) *buffer.Buffer {
+	buf := _syslogPool.Get()
+	buf.AppendByte('<')
+	buf.AppendInt(int64(c.facility*8 + SyslogSeverity(ent.Level)))
+	buf.AppendByte('>')
+	if c.format == RFC3164 {
+		buf.AppendString(ent.Time.Format(time.Stamp))
+		buf.AppendByte(' ')
+		buf.AppendString(c.hostname)
+		buf.AppendByte(' ')
+		buf.AppendString(c.appName)
+		buf.AppendByte('[')
+		buf.AppendString(c.pid)
+		buf.AppendString("]: ")
+		return buf
+	}
+	buf.AppendString("1 ")
+	buf.AppendString(ent.Time.Format("2006-01-02T15:04:05.000000Z07:00"))
+	buf.AppendByte(' ')
+	buf.AppendString(c.hostname)
+	buf.AppendByte(' ')
+	buf.AppendString(c.appName)
+	buf.AppendByte(' ')
+	buf.AppendString(c.pid)
+	buf.AppendString(" - - ")
+	return buf
+}
+
+func (c *syslogCore) Sync() error {
+	return c.out.Sync()
+}
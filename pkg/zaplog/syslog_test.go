@@ -0,0 +1,124 @@
+package log
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestSyslogUDP5424(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	core, err := NewSyslogCore(SyslogConfig{
+		Network:  "udp",
+		Addr:     pc.LocalAddr().String(),
+		Facility: FacilityLocal0,
+		AppName:  "demo app",
+		Hostname: "host1",
+	}, zapcore.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := NewJSONLogger(WithDisableConsole(), WithCores(core))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Warn("udp", zap.Int("n", 1))
+
+	buf := make([]byte, 2048)
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// local0(16)*8 + warning(4) = 132
+	if !strings.HasPrefix(msg, "<132>1 ") || !strings.Contains(msg, " host1 demo_app ") ||
+		!strings.Contains(msg, " - - {") || !strings.HasSuffix(msg, `"msg":"udp","n":1}`) {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
+
+func TestSyslogTCP3164(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	frames := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			size, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(size))
+			frame := make([]byte, n)
+			if _, err := io.ReadFull(r, frame); err != nil {
+				return
+			}
+			frames <- string(frame)
+		}
+	}()
+
+	core, err := NewSyslogCore(SyslogConfig{Network: "tcp", Addr: ln.Addr().String(), Format: RFC3164, AppName: "app", Hostname: "h"}, zapcore.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(core)
+	logger.Debug("skipped")
+	logger.Error("first")
+	logger.Info("second")
+	for _, want := range []string{`<11>`, `<14>`} {
+		select {
+		case frame := <-frames:
+			if !strings.HasPrefix(frame, want) || !strings.Contains(frame, " h app[") {
+				t.Fatalf("frame %q, want prefix %s", frame, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no frame received")
+		}
+	}
+}
+
+func TestSyslogUnixgram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "syslog.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skip("unixgram not supported:", err)
+	}
+	defer conn.Close()
+
+	core, err := NewSyslogCore(SyslogConfig{Network: "unix", Addr: path}, zapcore.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zap.New(core).Info("local")
+	buf := make([]byte, 2048)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<14>1 ") || !strings.Contains(msg, `"msg":"local"`) {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
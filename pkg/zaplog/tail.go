@@ -0,0 +1,295 @@
+package log
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// TailBuffersConfig NewTailBuffers 的配置
+type TailBuffersConfig struct {
+	// CaptureInfo 只缓冲 info 及以上被过滤掉的日志, 默认从 debug 开始缓冲
+	CaptureInfo bool
+	// FlushLevel 同一请求出现该等级及以上的日志时先写出缓冲, 低于 WarnLevel (包括零值) 时为 ErrorLevel
+	FlushLevel zapcore.Level
+	MaxEntries int           // 每个请求最多缓冲多少条, 超过时丢弃最早的, 默认 256
+	MaxBuffers int           // 同时存在的缓冲数, 超过时新请求不缓冲, 默认 1000
+	MaxAge     time.Duration // 没有调用 done 的缓冲在达到上限时可被回收的时间, 默认 1m
+}
+
+// TailBuffers 按请求缓冲被等级过滤掉的日志, 请求出错时补写, 否则丢弃:
+//
+//	ctx, done := tails.Context(ctx)
+//	defer done()
+//	logger.DebugCtx(ctx, "query", ...) // 线上 info 等级, 先放在缓冲里
+//	logger.ErrorCtx(ctx, "failed", ...) // 先写出上面的 debug, 再写这条
+//
+// 缓冲只写到 filecore 的 writer, 不经过 WithExtraCores 的 core.
+// 缓冲的 field 需要通过 With 带上 (ZapLogOper 的 ctx 方法和 WithContext 会自动 With),
+// 只在日志的 fields 中的不会被缓冲; 没有缓冲的 logger 仍按原来的等级过滤
+type TailBuffers struct {
+	cfg     TailBuffersConfig
+	capture zapcore.Level
+
+	mu       sync.Mutex
+	buffers  map[*tailBuffer]struct{}
+	live     int32
+	rejected uint64
+}
+
+type tailBuffer struct {
+	owner   *TailBuffers
+	created time.Time
+
+	mu      sync.Mutex
+	entries []tailEntry
+	dropped int
+	closed  bool
+}
+
+type tailEntry struct {
+	core   zapcore.Core
+	ent    zapcore.Entry
+	fields []zapcore.Field
+}
+
+func NewTailBuffers(cfg TailBuffersConfig) *TailBuffers {
+	if cfg.FlushLevel < zapcore.WarnLevel {
+		cfg.FlushLevel = zapcore.ErrorLevel
+	}
+	capture := zapcore.DebugLevel
+	if cfg.CaptureInfo {
+		capture = zapcore.InfoLevel
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = 256
+	}
+	if cfg.MaxBuffers <= 0 {
+		cfg.MaxBuffers = 1000
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = time.Minute
+	}
+	return &TailBuffers{cfg: cfg, capture: capture, buffers: make(map[*tailBuffer]struct{})}
+}
+
+// Start 创建一个请求的缓冲, 返回的 field 通过 SetContext 或 With 带上, done 在请求结束时调用.
+// 缓冲数达到上限时返回 zap.Skip(), 该请求不缓冲
+func (t *TailBuffers) Start() (zap.Field, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.buffers) >= t.cfg.MaxBuffers {
+		t.expire()
+	}
+	if len(t.buffers) >= t.cfg.MaxBuffers {
+		atomic.AddUint64(&t.rejected, 1)
+		return zap.Skip(), func() {}
+	}
+	b := &tailBuffer{owner: t, created: time.Now()}
+	t.buffers[b] = struct{}{}
+	atomic.AddInt32(&t.live, 1)
+	// SkipType 的 field 不会被 encoder 输出
+	return zap.Field{Type: zapcore.SkipType, Interface: b}, b.release
+}
+
+// Context 同 Start, field 通过 SetContext 放进 ctx
+func (t *TailBuffers) Context(ctx context.Context) (context.Context, func()) {
+	field, done := t.Start()
+	if field.Interface == nil {
+		return ctx, done
+	}
+	return SetContext(ctx, field), done
+}
+
+// Live 当前的缓冲数
+func (t *TailBuffers) Live() int {
+	return int(atomic.LoadInt32(&t.live))
+}
+
+// Rejected 因达到 MaxBuffers 没有缓冲的请求数
+func (t *TailBuffers) Rejected() uint64 {
+	return atomic.LoadUint64(&t.rejected)
+}
+
+// WrapCore 一般包装 filecore 这类按等级路由的 core, 缓冲的日志直接调用它的 Write
+func (t *TailBuffers) WrapCore(core zapcore.Core) zapcore.Core {
+	return &tailCore{Core: core, tails: t}
+}
+
+// Option 用于 *zap.Logger: logger.WithOptions(t.Option())
+func (t *TailBuffers) Option() zap.Option {
+	return zap.WrapCore(t.WrapCore)
+}
+
+// expire 回收超过 MaxAge 的缓冲并清空, 调用时持有 mu
+func (t *TailBuffers) expire() {
+	now := time.Now()
+	for b := range t.buffers {
+		if now.Sub(b.created) >= t.cfg.MaxAge {
+			b.close()
+			delete(t.buffers, b)
+			atomic.AddInt32(&t.live, -1)
+		}
+	}
+}
+
+// release 只从计数中移除, 异步队列里还没处理的日志仍然可以写入和补写,
+// 之后缓冲随 ctx 一起被回收
+func (b *tailBuffer) release() {
+	t := b.owner
+	t.mu.Lock()
+	if _, ok := t.buffers[b]; ok {
+		delete(t.buffers, b)
+		atomic.AddInt32(&t.live, -1)
+	}
+	t.mu.Unlock()
+}
+
+func (b *tailBuffer) close() {
+	b.mu.Lock()
+	b.closed, b.entries = true, nil
+	b.mu.Unlock()
+}
+
+func (b *tailBuffer) add(e tailEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	if len(b.entries) >= b.owner.cfg.MaxEntries {
+		b.entries = append(b.entries[:0], b.entries[1:]...)
+		b.dropped++
+	}
+	b.entries = append(b.entries, e)
+}
+
+// flush 按顺序写出缓冲的日志, 第一条带上被丢弃的条数
+func (b *tailBuffer) flush() error {
+	b.mu.Lock()
+	entries, dropped := b.entries, b.dropped
+	b.entries, b.dropped = nil, 0
+	b.mu.Unlock()
+
+	var err error
+	for i, e := range entries {
+		fields := e.fields
+		if i == 0 && dropped > 0 {
+			fields = append(fields, zap.Int("tail_dropped", dropped))
+		}
+		if werr := e.core.Write(e.ent, fields); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+type tailCore struct {
+	zapcore.Core
+	tails *TailBuffers
+	buf   *tailBuffer // With 带上的缓冲
+}
+
+// Enabled 只有 With 带上了缓冲才放行被过滤掉的等级
+func (c *tailCore) Enabled(lvl zapcore.Level) bool {
+	return c.Core.Enabled(lvl) || c.buf != nil && lvl >= c.tails.capture
+}
+
+func (c *tailCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &tailCore{Core: c.Core.With(fields), tails: c.tails, buf: c.buf}
+	if b := c.tails.find(fields); b != nil {
+		clone.buf = b
+	}
+	return clone
+}
+
+func (c *tailCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	switch {
+	case ent.Level >= c.tails.cfg.FlushLevel:
+		// 先写出缓冲, 再按原来的 core 写这一条
+		ce = ce.AddCore(ent, tailFlusher{c})
+		return c.Core.Check(ent, ce)
+	case c.Core.Enabled(ent.Level):
+		return c.Core.Check(ent, ce)
+	case c.buf != nil && ent.Level >= c.tails.capture:
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write 不经过 Check 直接调用时 (如异步写) 也要能正确路由
+func (c *tailCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	b := c.buffer(fields)
+	if ent.Level >= c.tails.cfg.FlushLevel && b != nil {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	if c.Core.Enabled(ent.Level) {
+		return c.Core.Write(ent, fields)
+	}
+	if b != nil {
+		b.add(tailEntry{core: c.Core, ent: ent, fields: append([]zapcore.Field(nil), fields...)})
+	}
+	return nil
+}
+
+func (c *tailCore) buffer(fields []zapcore.Field) *tailBuffer {
+	if b := c.tails.find(fields); b != nil {
+		return b
+	}
+	return c.buf
+}
+
+// tailField SetContext 放进 ctx 的缓冲, ctx 方法用 With 带上, tailCore 在 Check 时只看 With 的缓冲
+func tailField(ctx context.Context) (zap.Field, bool) {
+	fields := contextFields(ctx)
+	for i := len(fields) - 1; i >= 0; i-- {
+		if _, ok := fields[i].Interface.(*tailBuffer); ok && fields[i].Type == zapcore.SkipType {
+			return fields[i], true
+		}
+	}
+	return zap.Field{}, false
+}
+
+func tailLogger(log ZapLogOper, ctx context.Context) ZapLogOper {
+	if f, ok := tailField(ctx); ok {
+		return log.With(f)
+	}
+	return log
+}
+
+func (t *TailBuffers) find(fields []zapcore.Field) *tailBuffer {
+	for i := len(fields) - 1; i >= 0; i-- {
+		if fields[i].Type != zapcore.SkipType {
+			continue
+		}
+		if b, ok := fields[i].Interface.(*tailBuffer); ok && b.owner == t {
+			return b
+		}
+	}
+	return nil
+}
+
+// tailFlusher Check 中放在原来的 core 之前, 只负责写出缓冲
+type tailFlusher struct {
+	c *tailCore
+}
+
+func (f tailFlusher) Enabled(zapcore.Level) bool        { return true }
+func (f tailFlusher) With([]zapcore.Field) zapcore.Core { return f }
+func (f tailFlusher) Sync() error                       { return nil }
+func (f tailFlusher) Check(_ zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce
+}
+
+func (f tailFlusher) Write(_ zapcore.Entry, fields []zapcore.Field) error {
+	if b := f.c.buffer(fields); b != nil {
+		return b.flush()
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package log
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestTailBuffers(t *testing.T) {
+	for _, mode := range []WriteMode{WriteModeSync, WriteModeAsync, WriteModeHybrid} {
+		out := &syncBuffer{}
+		tails := NewTailBuffers(TailBuffersConfig{MaxEntries: 2})
+		l := NewZapLoggerWithOptions(nil, WithWriteMode(mode), WithTailBuffers(tails), WithFallbackWriters(out))
+
+		ok, done1 := tails.Context(context.Background())
+		l.DebugCtx(ok, "ok debug")
+		l.InfoCtx(ok, "ok info")
+		done1()
+
+		failed, done2 := tails.Context(SetContext(context.Background(), zap.String("req", "r2")))
+		l.DebugCtx(failed, "step 1")
+		l.DebugCtx(failed, "step 2")
+		l.DebugCtx(failed, "step 3")
+		l.Debug("no request")
+		l.ErrorCtx(failed, "request failed")
+		if tails.Live() != 1 {
+			t.Fatalf("live %d", tails.Live())
+		}
+		done2()
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 4 {
+			t.Fatalf("mode %d lines %q", mode, lines)
+		}
+		// 超过 MaxEntries 丢弃最早的 step 1
+		for i, want := range []string{`"msg":"ok info"`, `"msg":"step 2"`, `"msg":"step 3"`, `"msg":"request failed"`} {
+			if !strings.Contains(lines[i], want) {
+				t.Fatalf("mode %d line %d %q, want %s", mode, i, lines[i], want)
+			}
+		}
+		if !strings.Contains(lines[1], `"level":"debug"`) || !strings.Contains(lines[1], `"req":"r2"`) || !strings.Contains(lines[1], `"tail_dropped":1`) {
+			t.Fatalf("mode %d flushed line %q", mode, lines[1])
+		}
+		if tails.Live() != 0 {
+			t.Fatalf("live %d after done", tails.Live())
+		}
+	}
+}
+
+func TestTailBuffersCap(t *testing.T) {
+	tails := NewTailBuffers(TailBuffersConfig{MaxBuffers: 1, MaxAge: 50 * time.Millisecond})
+	out := &syncBuffer{}
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), out, zapcore.InfoLevel), tails.Option())
+
+	f1, _ := tails.Start() // 忘记调用 done
+	f2, done2 := tails.Start()
+	defer done2()
+	if f2.Type != zapcore.SkipType || f2.Interface != nil || tails.Rejected() != 1 {
+		t.Fatalf("second buffer %+v, rejected %d", f2, tails.Rejected())
+	}
+	// 没有缓冲的 logger 不放行被过滤的等级
+	if logger.Core().Enabled(zapcore.DebugLevel) || logger.With(f2).Core().Enabled(zapcore.DebugLevel) || !logger.With(f1).Core().Enabled(zapcore.DebugLevel) {
+		t.Fatal("debug enabled without a buffer")
+	}
+	// 没有缓冲的日志照常按等级过滤
+	logger.With(f2).Debug("dropped")
+	logger.With(f1).Debug("kept")
+	logger.With(f1).Error("failed")
+	if s := out.String(); strings.Contains(s, "dropped") || !strings.Contains(s, `"msg":"kept"`) {
+		t.Fatalf("output %q", s)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if f3, _ := tails.Start(); f3.Interface == nil || tails.Live() != 1 {
+		t.Fatalf("expired buffer not reclaimed, live %d", tails.Live())
+	}
+}
+
+func TestTailBuffersCaptureInfo(t *testing.T) {
+	tails := NewTailBuffers(TailBuffersConfig{CaptureInfo: true})
+	out := &syncBuffer{}
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), out, zapcore.WarnLevel), tails.Option())
+
+	f, done := tails.Start()
+	defer done()
+	logger.With(f).Debug("not captured")
+	logger.With(f).Info("captured")
+	logger.With(f).Error("failed")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"msg":"captured"`) || !strings.Contains(lines[1], `"msg":"failed"`) {
+		t.Fatalf("lines %q", lines)
+	}
+}
@@ -2,9 +2,9 @@ package log
 
 import (
 	"fmt"
-	"github.com/natefinch/lumberjack"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -24,7 +24,13 @@ type Options struct {
 	MaxBackups     int           // 最多存在多少个切片文件
 	MaxAge         int           //保存的最大天数
 	Development    bool          //是否是开发模式
+
+	// Rotation 其余切割配置 (压缩, 备份文件名, 回调等), 其中的 MaxSize/MaxBackups/MaxAge 被上面的字段覆盖
+	Rotation RotationOptions
 	zap.Config
+
+	Cores []zapcore.Core // 额外的 core, 如 NewSyslogCore, 使用各自的等级
+	Loki  *LokiConfig    // 推送到 Loki, AppName 作为 app label
 }
 
 type ModOptions func(options *Options)
@@ -43,17 +49,28 @@ type Logger struct {
 	Opts        *Options `json:"opts"`
 	zapConfig   zap.Config
 	initialized bool
+	closeCores  func() // 关闭 SetOutputPaths 的输出和 Loki core
 }
 
+// NewLogger 同 BuildLogger, 出错 (如 SetOutputPaths 的路径无法打开) 时 panic
 func NewLogger(mod ...ModOptions) *zap.Logger {
-	l = &Logger{}
-	l.Lock()
-	defer l.Unlock()
-	if l.initialized {
-		l.Info("[NewLogger] logger initEd")
-		return nil
+	logger, err := BuildLogger(mod...)
+	if err != nil {
+		panic(err)
 	}
-	l.Opts = &Options{
+	return logger
+}
+
+// BuildLogger 按 mod 创建 logger, 输出路径无法打开, Loki 配置错误, AppName 的等级已注册等返回给调用方
+func BuildLogger(mod ...ModOptions) (*zap.Logger, error) {
+	nl := &Logger{}
+	nl.Lock()
+	defer nl.Unlock()
+	if nl.initialized {
+		nl.Info("[NewLogger] logger initEd")
+		return nil, nil
+	}
+	nl.Opts = &Options{
 		LogFileDir:     "",
 		AppName:        "app",
 		ErrorFileName:  "error.log",
@@ -62,54 +79,64 @@ func NewLogger(mod ...ModOptions) *zap.Logger {
 		MaxSize:        100,
 		MaxBackups:     60,
 		MaxAge:         30,
+		Rotation:       RotationOptions{LocalTime: true},
 	}
 
-	if l.Opts.LogFileDir == "" {
-		l.Opts.LogFileDir, _ = filepath.Abs(filepath.Dir(filepath.Join(".")))
-		l.Opts.LogFileDir += sp + "logs" + sp
+	if nl.Opts.LogFileDir == "" {
+		nl.Opts.LogFileDir, _ = filepath.Abs(filepath.Dir(filepath.Join(".")))
+		nl.Opts.LogFileDir += sp + "logs" + sp
 	}
-	if l.Opts.Development {
-		l.zapConfig = zap.NewDevelopmentConfig()
-		l.zapConfig.EncoderConfig.EncodeTime = timeEncoder
+	if nl.Opts.Development {
+		nl.zapConfig = zap.NewDevelopmentConfig()
+		nl.zapConfig.EncoderConfig.EncodeTime = timeEncoder
 	} else {
-		l.zapConfig = zap.NewProductionConfig()
-		l.zapConfig.EncoderConfig.EncodeTime = timeUnixNano
+		nl.zapConfig = zap.NewProductionConfig()
+		nl.zapConfig.EncoderConfig.EncodeTime = timeUnixNano
 	}
-	if l.Opts.OutputPaths == nil || len(l.Opts.OutputPaths) == 0 {
-		l.zapConfig.OutputPaths = []string{"stdout"}
+	if nl.Opts.OutputPaths == nil || len(nl.Opts.OutputPaths) == 0 {
+		nl.zapConfig.OutputPaths = []string{"stdout"}
 	}
-	if l.Opts.ErrorOutputPaths == nil || len(l.Opts.ErrorOutputPaths) == 0 {
-		l.zapConfig.OutputPaths = []string{"stderr"}
+	if nl.Opts.ErrorOutputPaths == nil || len(nl.Opts.ErrorOutputPaths) == 0 {
+		nl.zapConfig.OutputPaths = []string{"stderr"}
 	}
 	for _, fn := range mod {
-		fn(l.Opts)
+		fn(nl.Opts)
+	}
+	nl.zapConfig.Level.SetLevel(nl.Opts.Level)
+	// AppName 为空或已被其他 logger 注册时返回错误, 不覆盖
+	if err := RegisterLevel(nl.Opts.AppName, nl.zapConfig.Level); err != nil {
+		return nil, err
 	}
-	l.zapConfig.Level.SetLevel(l.Opts.Level)
-	l.init()
-	l.initialized = true
-	return l.Logger
+	if err := nl.init(); err != nil {
+		_ = levelRegistration{name: nl.Opts.AppName, lvl: nl.zapConfig.Level}.Close()
+		return nil, err
+	}
+	nl.initialized = true
+	l = nl
+	return nl.Logger, nil
 }
 
-func (l *Logger) init() {
+func (l *Logger) init() error {
 	l.setSyncs()
-	var err error
-	l.Logger, err = l.zapConfig.Build(l.cores())
+	cores, closeCores, err := l.cores()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	l.Logger, err = l.zapConfig.Build(cores)
+	if err != nil {
+		closeCores()
+		return err
 	}
+	l.closeCores = closeCores
 	defer l.Logger.Sync()
+	return nil
 }
 
 func (l *Logger) setSyncs() {
+	rotation := l.Opts.Rotation
+	rotation.MaxSize, rotation.MaxBackups, rotation.MaxAge = l.Opts.MaxSize, l.Opts.MaxBackups, l.Opts.MaxAge
 	f := func(fN string) zapcore.WriteSyncer {
-		return zapcore.AddSync(&lumberjack.Logger{
-			Filename:   l.Opts.LogFileDir + sp + l.Opts.AppName + "-" + fN,
-			MaxSize:    l.Opts.MaxSize,
-			MaxBackups: l.Opts.MaxBackups,
-			MaxAge:     l.Opts.MaxAge,
-			Compress:   false,
-			LocalTime:  true,
-		})
+		return NewRotateWriter(l.Opts.LogFileDir+sp+l.Opts.AppName+"-"+fN, rotation)
 	}
 	errWS = f(l.Opts.ErrorFileName)
 	normalWS = f(l.Opts.NormalFileName)
@@ -132,6 +159,35 @@ func SetMaxAge(MaxAge int) ModOptions {
 	}
 }
 
+// SetRotation 整体替换切割配置, 包括 MaxSize/MaxBackups/MaxAge
+func SetRotation(rotation RotationOptions) ModOptions {
+	return func(option *Options) {
+		option.Rotation = rotation
+		option.MaxSize, option.MaxBackups, option.MaxAge = rotation.MaxSize, rotation.MaxBackups, rotation.MaxAge
+	}
+}
+
+// SetOutputPaths 额外的输出, 支持 zap.Open 的路径和本包的 rotate/tcp/udp/unix scheme, 由 CloseLogger 关闭
+func SetOutputPaths(paths ...string) ModOptions {
+	return func(option *Options) {
+		option.OutputPaths = paths
+	}
+}
+
+// SetCores 额外的 core, 如 NewSyslogCore
+func SetCores(cores ...zapcore.Core) ModOptions {
+	return func(option *Options) {
+		option.Cores = append(option.Cores, cores...)
+	}
+}
+
+// SetLoki 推送到 Loki, 没有设置 app label 时使用 AppName
+func SetLoki(cfg LokiConfig) ModOptions {
+	return func(option *Options) {
+		option.Loki = &cfg
+	}
+}
+
 func SetLogFileDir(LogFileDir string) ModOptions {
 	return func(option *Options) {
 		option.LogFileDir = LogFileDir
@@ -164,7 +220,9 @@ func SetDevelopment(Development bool) ModOptions {
 		option.Development = Development
 	}
 }
-func (l *Logger) cores() zap.Option {
+
+// cores 出错时关闭已经打开的输出, 成功时返回的 closeCores 由 CloseLogger 调用
+func (l *Logger) cores() (opt zap.Option, closeCores func(), err error) {
 	fileEncoder := zapcore.NewJSONEncoder(l.zapConfig.EncoderConfig)
 	encoderConfig := zap.NewDevelopmentEncoderConfig()
 	encoderConfig.EncodeTime = timeEncoder
@@ -175,12 +233,43 @@ func (l *Logger) cores() zap.Option {
 		return lvl == zapcore.ErrorLevel && zapcore.ErrorLevel-l.zapConfig.Level.Level() > -1
 	})
 	normalPriority := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return zapcore.DebugLevel-l.zapConfig.Level.Level() > -1
+		return lvl >= l.zapConfig.Level.Level()
 	})
 	cores := []zapcore.Core{
 		zapcore.NewCore(fileEncoder, errWS, errPriority),
 		zapcore.NewCore(fileEncoder, normalWS, normalPriority),
 	}
+	closeCores = func() {}
+	if len(l.Opts.OutputPaths) > 0 {
+		outputWS, closeOutput, err := OpenSinks(l.Opts.OutputPaths...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("log: open output paths: %w", err)
+		}
+		closeCores = closeOutput
+		cores = append(cores, zapcore.NewCore(fileEncoder, outputWS, normalPriority))
+	}
+	cores = append(cores, l.Opts.Cores...)
+	if l.Opts.Loki != nil {
+		cfg := *l.Opts.Loki
+		if _, ok := cfg.Labels["app"]; !ok {
+			labels := map[string]string{"app": l.Opts.AppName}
+			for k, v := range cfg.Labels {
+				labels[k] = v
+			}
+			cfg.Labels = labels
+		}
+		lokiCore, err := NewLokiCore(cfg, l.zapConfig.Level)
+		if err != nil {
+			closeCores()
+			return nil, nil, err
+		}
+		closeOutput := closeCores
+		closeCores = func() {
+			_ = lokiCore.(io.Closer).Close()
+			closeOutput()
+		}
+		cores = append(cores, lokiCore)
+	}
 	if l.Opts.Development {
 		cores = append(cores, []zapcore.Core{
 			zapcore.NewCore(consoleEncoder, errorConsoleWS, errPriority),
@@ -189,7 +278,7 @@ func (l *Logger) cores() zap.Option {
 	}
 	return zap.WrapCore(func(c zapcore.Core) zapcore.Core {
 		return zapcore.NewTee(cores...)
-	})
+	}), closeCores, nil
 }
 func timeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 	enc.AppendString(t.Format("2006-01-02 15:04:05"))
@@ -234,3 +323,27 @@ func InitLog(appname, level, fileDir string, devModule bool) {
 func GetLogger() *zap.Logger {
 	return logger
 }
+
+// CloseLogger 程序退出前调用, 写完 NewLogger 的日志后关闭 SetOutputPaths 打开的输出和 Loki core
+func CloseLogger() {
+	if l == nil || !l.initialized {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.closeCores == nil {
+		return
+	}
+	_ = l.Logger.Sync()
+	l.closeCores()
+	l.closeCores = nil
+}
+
+// GetAtomicLevel 返回 NewLogger 使用的等级, 可传给 WithAtomicLevel 或挂到 HTTP 上;
+// 未初始化时 ok 为 false
+func GetAtomicLevel() (lvl zap.AtomicLevel, ok bool) {
+	if l == nil || !l.initialized {
+		return lvl, false
+	}
+	return l.zapConfig.Level, true
+}
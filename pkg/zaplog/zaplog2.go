@@ -1,10 +1,16 @@
 package log
 
 import (
-	"errors"
+	"context"
+	"fmt"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"io"
+	"os"
+	"reflect"
 	"sync"
+	"sync/atomic"
 )
 
 // async zaplog
@@ -16,25 +22,88 @@ type ZapLogOper interface {
 	DPanic(msg string, fields ...zap.Field)
 	Panic(msg string, fields ...zap.Field)
 	Fatal(msg string, fields ...zap.Field)
+
+	// ctx 版本会带上 SetContext 设置的 fields
+	DebugCtx(ctx context.Context, msg string, fields ...zap.Field)
+	InfoCtx(ctx context.Context, msg string, fields ...zap.Field)
+	WarnCtx(ctx context.Context, msg string, fields ...zap.Field)
+	ErrorCtx(ctx context.Context, msg string, fields ...zap.Field)
+	DPanicCtx(ctx context.Context, msg string, fields ...zap.Field)
+	PanicCtx(ctx context.Context, msg string, fields ...zap.Field)
+	FatalCtx(ctx context.Context, msg string, fields ...zap.Field)
+
+	// With 返回带固定 fields 的子 logger, 与父 logger 共用写入方式和等级
+	With(fields ...zap.Field) ZapLogOper
+	// Named 给子 logger 加名字, 多次调用以 "." 连接
+	Named(name string) ZapLogOper
+	// Sugar 返回 printf/key-value 风格的 logger, 写入方式与当前 logger 一致
+	Sugar() *zap.SugaredLogger
+	Level() zapcore.Level
+	SetLevel(lvl zapcore.Level)
+	// AtomicLevel 实现了 http.Handler, 可直接挂到 HTTP 上运行时调整等级
+	AtomicLevel() zap.AtomicLevel
+
+	// Sync 等异步队列中的日志写完, 再 Sync 所有 writer 和 core
+	Sync() error
+	// Close 退出前调用: Sync 后停止异步 worker, 关闭 WithOwnedClosers 交给 logger 的 writer 和 core,
+	// 其他 writer 由调用方关闭. 子 logger 共用同一次关闭, 之后的日志不再保证写出
+	Close() error
 }
 
 // 同步日志，直接写
 type synczaplogger struct {
 	zaplog *zap.Logger
+	level  zap.AtomicLevel
+	closer *zapCloser
 }
 
 // 异步日志
 type asynczaplogger struct {
 	zaplog   *zap.Logger
+	level    zap.AtomicLevel
 	masyslog *asynclogger
+	closer   *zapCloser
 }
 
+// 混合模式, syncLevel 及以上同步写
 type zaplogger struct {
-	zaplog   *zap.Logger
-	masyslog *asynclogger
+	zaplog    *zap.Logger
+	level     zap.AtomicLevel
+	masyslog  *asynclogger
+	syncLevel zapcore.Level
+	closer    *zapCloser
+}
+
+// zapCloser 同一个 logger 及其子 logger 只关闭一次
+type zapCloser struct {
+	once    sync.Once
+	closers []io.Closer
+	err     error
+}
+
+func (c *zapCloser) close(before func() error) error {
+	c.once.Do(func() {
+		err := before()
+		for _, cl := range c.closers {
+			err = multierr.Append(err, cl.Close())
+		}
+		c.err = err
+	})
+	return c.err
 }
 
-func setzaplogger(nwriters map[zapcore.Level]zapcore.WriteSyncer) *zap.Logger {
+// ownedCloser BreakerWriter 等包装的 writer 关闭时, 只关闭下游本包创建的 writer,
+// 调用方传入的 *os.File (包括 os.Stdout/os.Stderr) 和其他 io.Closer 由调用方自己关闭
+func ownedCloser(v interface{}) (io.Closer, bool) {
+	switch v.(type) {
+	case *RotateWriter, *HTTPSink, *BreakerWriter, *FailoverWriter, *netSink, *metricsWriter,
+		*alertCore, *elasticCore, *fluentCore, *lokiCore, *otlpCore:
+		return v.(io.Closer), true
+	}
+	return nil, false
+}
+
+func setzaplogger(nwriters map[zapcore.Level]zapcore.WriteSyncer, opt *zapLoggerOptions) (*zap.Logger, zap.AtomicLevel, *zapCloser) {
 	encoderConfig := zapcore.EncoderConfig{
 		TimeKey:        "time",
 		MessageKey:     "msg",
@@ -50,69 +119,383 @@ func setzaplogger(nwriters map[zapcore.Level]zapcore.WriteSyncer) *zap.Logger {
 		//EncodeName: zapcore.FullNameEncoder,
 	}
 	encoder := zapcore.NewJSONEncoder(encoderConfig)
-	lvlenabler := zap.NewAtomicLevel()
-	fcore := newfilecore(encoder, nwriters, lvlenabler)
-	zaplog := zap.New(fcore)
-	return zaplog
+	lvlenabler := opt.level
+	routes := opt.routes(nwriters)
+	fcore := newfilecore(encoder, routes, lvlenabler)
+	fcore.metrics, fcore.metricsName = opt.metrics, opt.metricsName
+	var core zapcore.Core = fcore
+	if opt.tails != nil {
+		core = opt.tails.WrapCore(core)
+	}
+	if len(opt.cores) > 0 {
+		core = zapcore.NewTee(append([]zapcore.Core{core}, opt.cores...)...)
+	}
+	if opt.errorGroups != nil {
+		core = zapcore.NewTee(core, opt.errorGroups.Core())
+	}
+	zaplog := zap.New(core, zap.ErrorOutput(opt.errorOutput))
+
+	return zaplog, lvlenabler, &zapCloser{closers: opt.closers}
+}
+
+// WriteMode NewZapLogger 的写入方式
+type WriteMode int
+
+const (
+	// WriteModeSync 调用方 goroutine 直接写
+	WriteModeSync WriteMode = iota
+	// WriteModeAsync 全部等级放入队列异步写
+	WriteModeAsync
+	// WriteModeHybrid 低于 syncLevel 异步写, syncLevel 及以上先刷完队列再同步写
+	WriteModeHybrid
+)
+
+// OverflowPolicy 异步队列满时的处理方式
+type OverflowPolicy int
+
+const (
+	// OverflowBlock 阻塞直到队列有空位
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop 丢弃当前日志
+	OverflowDrop
+	// OverflowSync 在调用方 goroutine 同步写
+	OverflowSync
+)
+
+type zapLoggerOptions struct {
+	mode         WriteMode
+	syncLevel    zapcore.Level
+	queueSize    int
+	workers      int
+	overflow     OverflowPolicy
+	levelWriters map[zapcore.Level][]zapcore.WriteSyncer
+	rangeWriters []levelRange
+	fallback     []zapcore.WriteSyncer
+	errorOutput  zapcore.WriteSyncer
+	level        zap.AtomicLevel
+	initLevel    *zapcore.Level
+	levelName    string
+	cores        []zapcore.Core
+	metrics      *Metrics
+	metricsName  string
+	errorGroups  *ErrorGroups
+	tails        *TailBuffers
+	closers      []io.Closer
+}
+
+// ZapLoggerOption NewZapLoggerWithOptions 的配置项
+type ZapLoggerOption func(*zapLoggerOptions)
+
+// WithWriteMode 设置写入方式, 默认 WriteModeSync
+func WithWriteMode(mode WriteMode) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.mode = mode
+	}
+}
+
+// WithHybridSyncLevel Hybrid 模式下同步写的最低等级, 默认 DPanicLevel
+func WithHybridSyncLevel(lvl zapcore.Level) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.syncLevel = lvl
+	}
 }
 
-func newsynczaplogger(nwriters map[zapcore.Level]zapcore.WriteSyncer) *synczaplogger {
+// WithQueueSize 异步队列长度
+func WithQueueSize(n int) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.queueSize = n
+	}
+}
+
+// WithWorkers 异步写的 goroutine 数, 大于 1 时不保证日志顺序
+func WithWorkers(n int) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.workers = n
+	}
+}
+
+// WithOverflowPolicy 队列满时的处理方式, 默认 OverflowBlock
+func WithOverflowPolicy(policy OverflowPolicy) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.overflow = policy
+	}
+}
+
+// WithAtomicLevel 使用外部的 AtomicLevel, 多个 logger 可共用一个等级,
+// 比如 GetAtomicLevel 返回的等级, 跟随 zaplog1
+func WithAtomicLevel(lvl zap.AtomicLevel) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.level = lvl
+	}
+}
+
+// WithLevelName 以 name 注册等级, 之后可通过 LevelHandler 和 ReloadLevels 调整, Close 时取消注册.
+// 同名的等级已注册时不注册, 错误写到 WithErrorOutput
+func WithLevelName(name string) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.levelName = name
+	}
+}
+
+// WithInitialLevel 初始等级, 默认 InfoLevel
+func WithInitialLevel(lvl zapcore.Level) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.initLevel = &lvl
+	}
+}
+
+// WithExtraCores 额外的 core, 如 NewSyslogCore, 与 filecore 同步或异步方式一致
+func WithExtraCores(cores ...zapcore.Core) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.cores = append(opt.cores, cores...)
+	}
+}
+
+// WithOwnedClosers 把 writer 或 core 的所有权交给 logger, logger 的 Close 写完日志后按顺序关闭它们,
+// 如 WithOwnedClosers(sink). 多个 logger 共用的 sink 不要交给其中一个
+func WithOwnedClosers(closers ...io.Closer) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.closers = append(opt.closers, closers...)
+	}
+}
+
+// WithMetrics 统计日志条数, 字节数, 写入错误, 异步模式下还有队列长度和丢弃数,
+// name 作为 sink 和 queue 的 label. 单个 writer 的耗时用 m.Writer 包装
+func WithMetrics(m *Metrics, name string) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.metrics, opt.metricsName = m, name
+	}
+}
+
+// WithErrorGroups 错误日志按指纹聚合到 g
+func WithErrorGroups(g *ErrorGroups) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.errorGroups = g
+	}
+}
+
+// WithTailBuffers 低于 logger 等级的日志按请求缓冲, 同一请求出错时补写, 见 TailBuffers
+func WithTailBuffers(t *TailBuffers) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.tails = t
+	}
+}
+
+// WithLevelWriters 给某个等级追加 writer, 与 nwriters 中同等级的 writer 一起写
+func WithLevelWriters(lvl zapcore.Level, ws ...zapcore.WriteSyncer) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		if opt.levelWriters == nil {
+			opt.levelWriters = make(map[zapcore.Level][]zapcore.WriteSyncer)
+		}
+		opt.levelWriters[lvl] = append(opt.levelWriters[lvl], ws...)
+	}
+}
+
+// WithMinLevelWriters lvl 及以上等级都写入 ws
+func WithMinLevelWriters(lvl zapcore.Level, ws ...zapcore.WriteSyncer) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.rangeWriters = append(opt.rangeWriters, levelRange{min: lvl, writers: ws})
+	}
+}
+
+// WithFallbackWriters 没有任何 writer 的等级写入 ws
+func WithFallbackWriters(ws ...zapcore.WriteSyncer) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.fallback = append(opt.fallback, ws...)
+	}
+}
+
+// WithErrorOutput writer 写失败等内部错误的输出, 默认 os.Stderr
+func WithErrorOutput(ws zapcore.WriteSyncer) ZapLoggerOption {
+	return func(opt *zapLoggerOptions) {
+		opt.errorOutput = ws
+	}
+}
+
+func (opt *zapLoggerOptions) routes(nwriters map[zapcore.Level]zapcore.WriteSyncer) *filecoreRoutes {
+	routes := &filecoreRoutes{
+		exact:    make(map[zapcore.Level][]zapcore.WriteSyncer),
+		ranges:   opt.rangeWriters,
+		fallback: opt.fallback,
+	}
+	for lvl, ws := range nwriters {
+		routes.exact[lvl] = append(routes.exact[lvl], ws)
+	}
+	for lvl, ws := range opt.levelWriters {
+		routes.exact[lvl] = append(routes.exact[lvl], ws...)
+	}
+	return routes
+}
+
+func newsynczaplogger(nwriters map[zapcore.Level]zapcore.WriteSyncer, opt *zapLoggerOptions) *synczaplogger {
 
 	retlogger := &synczaplogger{}
-	retlogger.zaplog = setzaplogger(nwriters)
+	retlogger.zaplog, retlogger.level, retlogger.closer = setzaplogger(nwriters, opt)
 	return retlogger
 }
 
-func newasynczaplogger(nwriters map[zapcore.Level]zapcore.WriteSyncer) *asynczaplogger {
+func newasynczaplogger(nwriters map[zapcore.Level]zapcore.WriteSyncer, opt *zapLoggerOptions) *asynczaplogger {
 	retlogger := &asynczaplogger{}
-	retlogger.zaplog = setzaplogger(nwriters)
+	retlogger.zaplog, retlogger.level, retlogger.closer = setzaplogger(nwriters, opt)
 	//设置异步的操作
-	retlogger.masyslog = newAsyncLogger()
+	retlogger.masyslog = newAsyncLogger(opt)
 	return retlogger
 }
 
+func newhybridzaplogger(nwriters map[zapcore.Level]zapcore.WriteSyncer, opt *zapLoggerOptions) *zaplogger {
+	retzaplogger := &zaplogger{syncLevel: opt.syncLevel}
+	retzaplogger.zaplog, retzaplogger.level, retzaplogger.closer = setzaplogger(nwriters, opt)
+	retzaplogger.masyslog = newAsyncLogger(opt)
+	return retzaplogger
+}
+
 // 返回接口
-func NewZapLogger(wtMode int, nwriters map[zapcore.Level]zapcore.WriteSyncer) ZapLogOper {
-	if wtMode == 0 {
-		return newsynczaplogger(nwriters)
-	} else {
-		return newasynczaplogger(nwriters)
+func NewZapLogger(wtMode WriteMode, nwriters map[zapcore.Level]zapcore.WriteSyncer) ZapLogOper {
+	return NewZapLoggerWithOptions(nwriters, WithWriteMode(wtMode))
+}
+
+// NewZapLoggerWithOptions 按 opts 创建 logger, 未设置的项使用默认值
+func NewZapLoggerWithOptions(nwriters map[zapcore.Level]zapcore.WriteSyncer, opts ...ZapLoggerOption) ZapLogOper {
+	opt := &zapLoggerOptions{
+		mode:        WriteModeSync,
+		syncLevel:   zapcore.DPanicLevel,
+		queueSize:   cst_defmaxlogquenums,
+		workers:     1,
+		overflow:    OverflowBlock,
+		errorOutput: zapcore.Lock(os.Stderr),
+		level:       zap.NewAtomicLevel(),
+	}
+	for _, f := range opts {
+		f(opt)
+	}
+	if opt.initLevel != nil {
+		opt.level.SetLevel(*opt.initLevel)
+	}
+	if opt.levelName != "" {
+		// 注册失败不影响写日志, 错误写到 errorOutput
+		if err := RegisterLevel(opt.levelName, opt.level); err != nil {
+			fmt.Fprintf(opt.errorOutput, "%v\n", err)
+		} else {
+			opt.closers = append(opt.closers, levelRegistration{name: opt.levelName, lvl: opt.level})
+		}
+	}
+	switch opt.mode {
+	case WriteModeSync:
+		return newsynczaplogger(nwriters, opt)
+	case WriteModeHybrid:
+		return newhybridzaplogger(nwriters, opt)
+	default:
+		return newasynczaplogger(nwriters, opt)
 	}
 }
 
+// NewAllZapLogger 等价于 WriteModeHybrid, DPanic 及以上同步写
 func NewAllZapLogger(nwriters map[zapcore.Level]zapcore.WriteSyncer) ZapLogOper {
-	retzaplogger := &zaplogger{}
-	retzaplogger.zaplog = setzaplogger(nwriters)
-	retzaplogger.masyslog = newAsyncLogger()
-	return retzaplogger
+	return NewZapLoggerWithOptions(nwriters, WithWriteMode(WriteModeHybrid))
 }
 
 func (log *zaplogger) Debug(msg string, fields ...zap.Field) {
-	log.masyslog.doAsyncLog(log.zaplog.Debug, msg, fields...)
+	log.write(zapcore.DebugLevel, log.zaplog.Debug, msg, fields)
 }
 
 func (log *zaplogger) Info(msg string, fields ...zap.Field) {
-	log.masyslog.doAsyncLog(log.zaplog.Info, msg, fields...)
+	log.write(zapcore.InfoLevel, log.zaplog.Info, msg, fields)
 }
 
 func (log *zaplogger) Warn(msg string, fields ...zap.Field) {
-	log.masyslog.doAsyncLog(log.zaplog.Warn, msg, fields...)
+	log.write(zapcore.WarnLevel, log.zaplog.Warn, msg, fields)
 }
 
 func (log *zaplogger) Error(msg string, fields ...zap.Field) {
-	log.masyslog.doAsyncLog(log.zaplog.Error, msg, fields...)
+	log.write(zapcore.ErrorLevel, log.zaplog.Error, msg, fields)
 }
 
 func (log *zaplogger) DPanic(msg string, fields ...zap.Field) {
-	log.zaplog.DPanic(msg, fields...)
+	log.write(zapcore.DPanicLevel, log.zaplog.DPanic, msg, fields)
 }
 
 func (log *zaplogger) Panic(msg string, fields ...zap.Field) {
-	log.zaplog.Panic(msg, fields...)
+	log.write(zapcore.PanicLevel, log.zaplog.Panic, msg, fields)
 }
 
 func (log *zaplogger) Fatal(msg string, fields ...zap.Field) {
-	log.zaplog.Fatal(msg, fields...)
+	log.write(zapcore.FatalLevel, log.zaplog.Fatal, msg, fields)
+}
+
+// syncLevel 及以上先等队列里已有的日志写完, 保证顺序, 再同步写
+func (log *zaplogger) write(lvl zapcore.Level, f func(msg string, fields ...zap.Field), msg string, fields []zap.Field) {
+	if lvl >= log.syncLevel {
+		log.masyslog.flush()
+		f(msg, fields...)
+		return
+	}
+	if log.zaplog.Core().Enabled(lvl) {
+		log.masyslog.doAsyncLog(f, msg, fields...)
+	}
+}
+
+func (log *zaplogger) DebugCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).Debug(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *zaplogger) InfoCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).Info(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *zaplogger) WarnCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).Warn(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *zaplogger) ErrorCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).Error(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *zaplogger) DPanicCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).DPanic(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *zaplogger) PanicCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).Panic(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *zaplogger) FatalCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).Fatal(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *zaplogger) With(fields ...zap.Field) ZapLogOper {
+	return &zaplogger{zaplog: log.zaplog.With(fields...), level: log.level, masyslog: log.masyslog, syncLevel: log.syncLevel, closer: log.closer}
+}
+
+func (log *zaplogger) Named(name string) ZapLogOper {
+	return &zaplogger{zaplog: log.zaplog.Named(name), level: log.level, masyslog: log.masyslog, syncLevel: log.syncLevel, closer: log.closer}
+}
+
+func (log *zaplogger) Sugar() *zap.SugaredLogger {
+	return log.zaplog.WithOptions(log.masyslog.wrapCore(log.syncLevel)).Sugar()
+}
+
+func (log *zaplogger) Level() zapcore.Level {
+	return log.level.Level()
+}
+
+func (log *zaplogger) SetLevel(lvl zapcore.Level) {
+	log.level.SetLevel(lvl)
+}
+
+func (log *zaplogger) AtomicLevel() zap.AtomicLevel {
+	return log.level
+}
+
+func (log *zaplogger) Sync() error {
+	log.masyslog.flush()
+	return log.zaplog.Sync()
+}
+
+func (log *zaplogger) Close() error {
+	return log.closer.close(func() error {
+		log.masyslog.close()
+		return log.zaplog.Sync()
+	})
 }
 
 // -------
@@ -144,32 +527,189 @@ func (log *synczaplogger) Fatal(msg string, fields ...zap.Field) {
 	log.zaplog.Fatal(msg, fields...)
 }
 
+// ctxLogger 直接调用 zaplog, 保持 caller 的层数
+func (log *synczaplogger) ctxLogger(ctx context.Context) *zap.Logger {
+	if f, ok := tailField(ctx); ok {
+		return log.zaplog.With(f)
+	}
+	return log.zaplog
+}
+
+func (log *synczaplogger) DebugCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	log.ctxLogger(ctx).Debug(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *synczaplogger) InfoCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	log.ctxLogger(ctx).Info(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *synczaplogger) WarnCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	log.ctxLogger(ctx).Warn(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *synczaplogger) ErrorCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	log.ctxLogger(ctx).Error(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *synczaplogger) DPanicCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	log.ctxLogger(ctx).DPanic(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *synczaplogger) PanicCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	log.ctxLogger(ctx).Panic(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *synczaplogger) FatalCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	log.ctxLogger(ctx).Fatal(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *synczaplogger) With(fields ...zap.Field) ZapLogOper {
+	return &synczaplogger{zaplog: log.zaplog.With(fields...), level: log.level, closer: log.closer}
+}
+
+func (log *synczaplogger) Named(name string) ZapLogOper {
+	return &synczaplogger{zaplog: log.zaplog.Named(name), level: log.level, closer: log.closer}
+}
+
+func (log *synczaplogger) Sugar() *zap.SugaredLogger {
+	return log.zaplog.Sugar()
+}
+
+func (log *synczaplogger) Level() zapcore.Level {
+	return log.level.Level()
+}
+
+func (log *synczaplogger) SetLevel(lvl zapcore.Level) {
+	log.level.SetLevel(lvl)
+}
+
+func (log *synczaplogger) AtomicLevel() zap.AtomicLevel {
+	return log.level
+}
+
+func (log *synczaplogger) Sync() error {
+	return log.zaplog.Sync()
+}
+
+func (log *synczaplogger) Close() error {
+	return log.closer.close(log.Sync)
+}
+
 func (log *asynczaplogger) Debug(msg string, fields ...zap.Field) {
-	log.masyslog.doAsyncLog(log.zaplog.Debug, msg, fields...)
+	if log.zaplog.Core().Enabled(zapcore.DebugLevel) {
+		log.masyslog.doAsyncLog(log.zaplog.Debug, msg, fields...)
+	}
 }
 
 func (log *asynczaplogger) Info(msg string, fields ...zap.Field) {
-	log.masyslog.doAsyncLog(log.zaplog.Info, msg, fields...)
+	if log.zaplog.Core().Enabled(zapcore.InfoLevel) {
+		log.masyslog.doAsyncLog(log.zaplog.Info, msg, fields...)
+	}
 }
 
 func (log *asynczaplogger) Warn(msg string, fields ...zap.Field) {
-	log.masyslog.doAsyncLog(log.zaplog.Warn, msg, fields...)
+	if log.zaplog.Core().Enabled(zapcore.WarnLevel) {
+		log.masyslog.doAsyncLog(log.zaplog.Warn, msg, fields...)
+	}
 }
 
 func (log *asynczaplogger) Error(msg string, fields ...zap.Field) {
-	log.masyslog.doAsyncLog(log.zaplog.Error, msg, fields...)
+	if log.zaplog.Core().Enabled(zapcore.ErrorLevel) {
+		log.masyslog.doAsyncLog(log.zaplog.Error, msg, fields...)
+	}
 }
 
 func (log *asynczaplogger) DPanic(msg string, fields ...zap.Field) {
-	log.masyslog.doAsyncLog(log.zaplog.DPanic, msg, fields...)
+	if log.zaplog.Core().Enabled(zapcore.DPanicLevel) {
+		log.masyslog.doAsyncLog(log.zaplog.DPanic, msg, fields...)
+	}
 }
 
+// Panic 和 Fatal 先写完队列中的日志, 再在调用方 goroutine 同步写, panic 和退出不发生在 worker 中
 func (log *asynczaplogger) Panic(msg string, fields ...zap.Field) {
-	log.masyslog.doAsyncLog(log.zaplog.Panic, msg, fields...)
+	log.masyslog.flush()
+	log.zaplog.Panic(msg, fields...)
 }
 
 func (log *asynczaplogger) Fatal(msg string, fields ...zap.Field) {
-	log.masyslog.doAsyncLog(log.zaplog.Fatal, msg, fields...)
+	log.masyslog.flush()
+	log.zaplog.Fatal(msg, fields...)
+}
+
+func (log *asynczaplogger) DebugCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).Debug(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *asynczaplogger) InfoCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).Info(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *asynczaplogger) WarnCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).Warn(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *asynczaplogger) ErrorCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).Error(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *asynczaplogger) DPanicCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).DPanic(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *asynczaplogger) PanicCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).Panic(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *asynczaplogger) FatalCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	tailLogger(log, ctx).Fatal(msg, withContextFields(ctx, fields)...)
+}
+
+func (log *asynczaplogger) With(fields ...zap.Field) ZapLogOper {
+	return &asynczaplogger{zaplog: log.zaplog.With(fields...), level: log.level, masyslog: log.masyslog, closer: log.closer}
+}
+
+func (log *asynczaplogger) Named(name string) ZapLogOper {
+	return &asynczaplogger{zaplog: log.zaplog.Named(name), level: log.level, masyslog: log.masyslog, closer: log.closer}
+}
+
+// Sugar panic 及以上等级同步写, 否则进程退出前来不及落盘
+func (log *asynczaplogger) Sugar() *zap.SugaredLogger {
+	return log.zaplog.WithOptions(log.masyslog.wrapCore(zapcore.DPanicLevel)).Sugar()
+}
+
+func (log *asynczaplogger) Level() zapcore.Level {
+	return log.level.Level()
+}
+
+func (log *asynczaplogger) SetLevel(lvl zapcore.Level) {
+	log.level.SetLevel(lvl)
+}
+
+func (log *asynczaplogger) AtomicLevel() zap.AtomicLevel {
+	return log.level
+}
+
+func (log *asynczaplogger) Sync() error {
+	log.masyslog.flush()
+	return log.zaplog.Sync()
+}
+
+func (log *asynczaplogger) Close() error {
+	return log.closer.close(func() error {
+		log.masyslog.close()
+		return log.zaplog.Sync()
+	})
+}
+
+// 合并 ctx 中的 fields, ctx 的在前
+func withContextFields(ctx context.Context, fields []zap.Field) []zap.Field {
+	cfields := contextFields(ctx)
+	if len(cfields) == 0 {
+		return fields
+	}
+	ret := make([]zap.Field, 0, len(cfields)+len(fields))
+	ret = append(ret, cfields...)
+	return append(ret, fields...)
 }
 
 // 异步的简单实现
@@ -186,9 +726,26 @@ var _asyncMsgPool = sync.Pool{
 	},
 }
 
-func newAsyncLogger() *asynclogger {
-	mret := &asynclogger{logMsgCh: make(chan *asyncMsg, cst_defmaxlogquenums)}
+func newAsyncLogger(opt *zapLoggerOptions) *asynclogger {
+	queueSize, workers := opt.queueSize, opt.workers
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	mret := &asynclogger{
+		logMsgCh: make(chan *asyncMsg, queueSize),
+		workers:  workers,
+		overflow: opt.overflow,
+	}
 	mret.start()
+	if opt.metrics != nil {
+		opt.metrics.ObserveQueue(opt.metricsName,
+			func() int { return len(mret.logMsgCh) },
+			func() int { return cap(mret.logMsgCh) },
+			mret.droppedCount)
+	}
 	return mret
 }
 
@@ -204,23 +761,47 @@ func putAsynMsg(e *asyncMsg) {
 }
 
 type asyncMsg struct {
-	msg    []byte
-	fields []zap.Field
-	f      func(msg string, fields ...zap.Field)
+	msg     []byte
+	fields  []zap.Field
+	f       func(msg string, fields ...zap.Field)
+	barrier *asyncBarrier
+}
+
+// flush 时每个 worker 收到一个 barrier 并停住, 全部到齐说明之前的日志都已写完
+type asyncBarrier struct {
+	wg      sync.WaitGroup
+	release chan struct{}
 }
 
 // 异步日志
 type asynclogger struct {
 	//异步队列数据
 	logMsgCh chan *asyncMsg
+	workers  int
+	overflow OverflowPolicy
+	dropped  uint64
+	flushMu  sync.Mutex
+	// closeMu 读锁保护入队, close 持写锁关闭 logMsgCh
+	closeMu sync.RWMutex
+	closed  bool
+	wg      sync.WaitGroup
 }
 
 func (log *asynclogger) start() {
-	go log.doWriteLog()
+	log.wg.Add(log.workers)
+	for i := 0; i < log.workers; i++ {
+		go log.doWriteLog()
+	}
 }
 
 func (log *asynclogger) doWriteLog() {
+	defer log.wg.Done()
 	for logdata := range log.logMsgCh {
+		if b := logdata.barrier; b != nil {
+			b.wg.Done()
+			<-b.release
+			continue
+		}
 		//可以分池来处理
 		logdata.f(string(logdata.msg), logdata.fields...)
 		putAsynMsg(logdata)
@@ -228,32 +809,197 @@ func (log *asynclogger) doWriteLog() {
 }
 
 func (log *asynclogger) doAsyncLog(f func(msg string, fields ...zap.Field), msg string, fields ...zap.Field) {
+	log.closeMu.RLock()
+	defer log.closeMu.RUnlock()
+	if log.closed {
+		// close 之后直接同步写
+		f(msg, fields...)
+		return
+	}
 	logdata := getAsyncMsg()
 	logdata.msg = append(logdata.msg, msg...)
 	logdata.fields = append(logdata.fields, fields...)
 	logdata.f = f
-	log.logMsgCh <- logdata
+	switch log.overflow {
+	case OverflowDrop:
+		select {
+		case log.logMsgCh <- logdata:
+		default:
+			putAsynMsg(logdata)
+			atomic.AddUint64(&log.dropped, 1)
+		}
+	case OverflowSync:
+		select {
+		case log.logMsgCh <- logdata:
+		default:
+			putAsynMsg(logdata)
+			f(msg, fields...)
+		}
+	default:
+		log.logMsgCh <- logdata
+	}
+}
+
+// flush 阻塞到调用前已入队的日志全部写完
+func (log *asynclogger) flush() {
+	log.flushMu.Lock()
+	defer log.flushMu.Unlock()
+	log.closeMu.RLock()
+	defer log.closeMu.RUnlock()
+	if log.closed {
+		return
+	}
+	b := &asyncBarrier{release: make(chan struct{})}
+	b.wg.Add(log.workers)
+	for i := 0; i < log.workers; i++ {
+		log.logMsgCh <- &asyncMsg{barrier: b}
+	}
+	b.wg.Wait()
+	close(b.release)
+}
+
+// close 写完队列中剩余的日志后停止 worker
+func (log *asynclogger) close() {
+	log.closeMu.Lock()
+	if log.closed {
+		log.closeMu.Unlock()
+		return
+	}
+	log.closed = true
+	close(log.logMsgCh)
+	log.closeMu.Unlock()
+	log.wg.Wait()
+}
+
+// droppedCount OverflowDrop 策略下丢弃的日志条数
+func (log *asynclogger) droppedCount() uint64 {
+	return atomic.LoadUint64(&log.dropped)
+}
+
+// asynccore 把 Write 放进 asynclogger 队列, syncLevel 及以上等级刷完队列后直接写
+type asynccore struct {
+	zapcore.Core
+	masyslog  *asynclogger
+	syncLevel zapcore.Level
+}
+
+func (log *asynclogger) wrapCore(syncLevel zapcore.Level) zap.Option {
+	return zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return &asynccore{Core: c, masyslog: log, syncLevel: syncLevel}
+	})
+}
+
+func (c *asynccore) With(fields []zapcore.Field) zapcore.Core {
+	return &asynccore{Core: c.Core.With(fields), masyslog: c.masyslog, syncLevel: c.syncLevel}
+}
+
+func (c *asynccore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *asynccore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if ent.Level >= c.syncLevel {
+		c.masyslog.flush()
+		return c.Core.Write(ent, fields)
+	}
+	c.masyslog.doAsyncLog(func(msg string, fields ...zap.Field) {
+		ent.Message = msg
+		_ = c.Core.Write(ent, fields)
+	}, ent.Message, fields...)
+	return nil
 }
 
 // zap.Core接口的实现
 type filecore struct {
 	zapcore.LevelEnabler
-	enc     zapcore.Encoder
-	writers map[zapcore.Level]zapcore.WriteSyncer
+	enc      zapcore.Encoder
+	writers  map[zapcore.Level][]zapcore.WriteSyncer
+	fallback []zapcore.WriteSyncer
+	sinks    []zapcore.WriteSyncer // 去重后的全部 writer, 用于 Sync
+
+	metrics     *Metrics // 可以为 nil
+	metricsName string
 }
 
 type FileCore interface {
 	zapcore.Core
 }
 
-func newfilecore(enc zapcore.Encoder, nwriters map[zapcore.Level]zapcore.WriteSyncer, enab zapcore.LevelEnabler) FileCore {
+// filecoreRoutes 等级到 writer 的路由:
+// 精确等级和 ranges 中 min <= 等级的 writer 都会写, 都没有时写 fallback
+type filecoreRoutes struct {
+	exact    map[zapcore.Level][]zapcore.WriteSyncer
+	ranges   []levelRange
+	fallback []zapcore.WriteSyncer
+}
+
+type levelRange struct {
+	min     zapcore.Level
+	writers []zapcore.WriteSyncer
+}
+
+func (r *filecoreRoutes) resolve(lvl zapcore.Level) []zapcore.WriteSyncer {
+	ws := append([]zapcore.WriteSyncer(nil), r.exact[lvl]...)
+	for _, rg := range r.ranges {
+		if lvl >= rg.min {
+			ws = append(ws, rg.writers...)
+		}
+	}
+	if len(ws) == 0 {
+		return r.fallback
+	}
+	return ws
+}
+
+// writers 去重后的全部 writer
+func (r *filecoreRoutes) writers() []zapcore.WriteSyncer {
+	var ret []zapcore.WriteSyncer
+	add := func(ws []zapcore.WriteSyncer) {
+		for _, w := range ws {
+			dup := false
+			for _, x := range ret {
+				// 不可比较的类型 (如含 slice 的结构体值) 不去重
+				if reflect.TypeOf(w) == reflect.TypeOf(x) && reflect.TypeOf(w).Comparable() && w == x {
+					dup = true
+					break
+				}
+			}
+			if !dup {
+				ret = append(ret, w)
+			}
+		}
+	}
+	for _, ws := range r.exact {
+		add(ws)
+	}
+	for _, rg := range r.ranges {
+		add(rg.writers)
+	}
+	add(r.fallback)
+	return ret
+}
+
+func newfilecore(enc zapcore.Encoder, routes *filecoreRoutes, enab zapcore.LevelEnabler) *filecore {
 	retcore := &filecore{
 		LevelEnabler: enab,
 		enc:          enc,
+		fallback:     routes.fallback,
+		sinks:        routes.writers(),
 	}
-	retcore.writers = make(map[zapcore.Level]zapcore.WriteSyncer)
-	for k, v := range nwriters {
-		retcore.writers[k] = v
+	// 构造时算好每个等级的 writer, 之后只读, clone 可以共用
+	retcore.writers = make(map[zapcore.Level][]zapcore.WriteSyncer)
+	for lvl := zapcore.DebugLevel; lvl <= zapcore.FatalLevel; lvl++ {
+		if ws := routes.resolve(lvl); len(ws) > 0 {
+			retcore.writers[lvl] = ws
+		}
+	}
+	for lvl := range routes.exact {
+		if _, ok := retcore.writers[lvl]; !ok {
+			retcore.writers[lvl] = routes.resolve(lvl)
+		}
 	}
 	return retcore
 }
@@ -273,37 +1019,55 @@ func (c *filecore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.C
 	return ce
 }
 
+// Write 返回的错误由 zap 写到 ErrorOutput
 func (c *filecore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
-	buf, err := c.enc.EncodeEntry(ent, fields)
-	if err != nil {
-		return err
+	ws, ok := c.writers[ent.Level]
+	if !ok {
+		ws = c.fallback
 	}
-	if _, ok := c.writers[ent.Level]; !ok {
-		return errors.New("no set level writers")
+	if len(ws) == 0 {
+		return fmt.Errorf("no set level writers for %s", ent.Level)
 	}
-	_, err = c.writers[ent.Level].Write(buf.Bytes())
-	buf.Free()
+	buf, err := c.enc.EncodeEntry(ent, fields)
 	if err != nil {
 		return err
 	}
-	if ent.Level > zapcore.ErrorLevel {
-		c.writers[ent.Level].Sync()
+	defer buf.Free()
+	if c.metrics != nil {
+		c.metrics.observeEntry(ent, buf.Len())
 	}
-	return nil
+	for _, w := range ws {
+		if _, werr := w.Write(buf.Bytes()); werr != nil {
+			if c.metrics != nil {
+				c.metrics.writeError(c.metricsName)
+			}
+			err = multierr.Append(err, werr)
+			continue
+		}
+		if ent.Level > zapcore.ErrorLevel {
+			err = multierr.Append(err, w.Sync())
+		}
+	}
+	return err
 }
 
 func (c *filecore) Sync() error {
-	return nil
+	var err error
+	for _, w := range c.sinks {
+		err = multierr.Append(err, w.Sync())
+	}
+	return err
 }
 
 func (c *filecore) clone() *filecore {
 	retclone := &filecore{
 		LevelEnabler: c.LevelEnabler,
 		enc:          c.enc.Clone(),
-	}
-	retclone.writers = make(map[zapcore.Level]zapcore.WriteSyncer)
-	for k, v := range c.writers {
-		retclone.writers[k] = v
+		writers:      c.writers,
+		fallback:     c.fallback,
+		sinks:        c.sinks,
+		metrics:      c.metrics,
+		metricsName:  c.metricsName,
 	}
 	return retclone
 }
@@ -0,0 +1,310 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// 并发安全的内存 WriteSyncer
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Sync() error {
+	return nil
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// 异步 logger 的输出需要等待
+func waitForOutput(t *testing.T, b *syncBuffer, substr string) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if out := b.String(); strings.Contains(out, substr) {
+			return out
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%q not found in output: %s", substr, b.String())
+	return ""
+}
+
+func allLevelWriters(ws zapcore.WriteSyncer) map[zapcore.Level]zapcore.WriteSyncer {
+	nwriters := make(map[zapcore.Level]zapcore.WriteSyncer)
+	for lvl := zapcore.DebugLevel; lvl <= zapcore.FatalLevel; lvl++ {
+		nwriters[lvl] = ws
+	}
+	return nwriters
+}
+
+func TestZapLogOper(t *testing.T) {
+	cases := map[string]func(map[zapcore.Level]zapcore.WriteSyncer) ZapLogOper{
+		"sync":  func(w map[zapcore.Level]zapcore.WriteSyncer) ZapLogOper { return NewZapLogger(0, w) },
+		"async": func(w map[zapcore.Level]zapcore.WriteSyncer) ZapLogOper { return NewZapLogger(1, w) },
+		"all":   NewAllZapLogger,
+	}
+	for name, newLogger := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf := &syncBuffer{}
+			l := newLogger(allLevelWriters(buf))
+
+			l.Debug("dropped")
+			if l.Level() != zapcore.InfoLevel {
+				t.Fatalf("default level = %v", l.Level())
+			}
+			l.SetLevel(zapcore.DebugLevel)
+
+			child := l.Named("svc").With(zap.String("app", "demo"))
+			ctx := SetContext(context.Background(), zap.String("reqid", "r1"))
+			child.DebugCtx(ctx, "with ctx", zap.Int("n", 1))
+			out := waitForOutput(t, buf, "with ctx")
+			for _, want := range []string{`"logger":"svc"`, `"app":"demo"`, `"reqid":"r1"`, `"n":1`} {
+				if !strings.Contains(out, want) {
+					t.Errorf("missing %s in %s", want, out)
+				}
+			}
+
+			child.Sugar().Infow("sugared", "k", "v")
+			out = waitForOutput(t, buf, "sugared")
+			if !strings.Contains(out, `"k":"v"`) {
+				t.Errorf("missing sugared field in %s", out)
+			}
+			if strings.Contains(out, "dropped") {
+				t.Errorf("debug written before SetLevel: %s", out)
+			}
+		})
+	}
+}
+
+func TestHybridFlushBeforeSync(t *testing.T) {
+	buf := &syncBuffer{}
+	l := NewZapLoggerWithOptions(allLevelWriters(buf),
+		WithWriteMode(WriteModeHybrid),
+		WithHybridSyncLevel(zapcore.ErrorLevel),
+		WithQueueSize(100),
+		WithWorkers(4),
+	)
+	for i := 0; i < 50; i++ {
+		l.Info("queued", zap.Int("i", i))
+	}
+	l.Error("sync")
+
+	// Error 返回时之前的日志必须已经写完, 不需要等待
+	out := buf.String()
+	if n := strings.Count(out, `"msg":"queued"`); n != 50 {
+		t.Fatalf("queued lines before sync write = %d, want 50", n)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(out), `"msg":"sync"}`) {
+		t.Fatalf("sync entry is not last: %s", out)
+	}
+}
+
+func TestAsyncPanicInCaller(t *testing.T) {
+	buf := &syncBuffer{}
+	l := NewZapLoggerWithOptions(allLevelWriters(buf), WithWriteMode(WriteModeAsync), WithWorkers(4))
+	for i := 0; i < 20; i++ {
+		l.Info("queued", zap.Int("i", i))
+	}
+	// panic 发生在调用方 goroutine, 之前排队的日志已经写完
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("recovered %v", r)
+			}
+		}()
+		l.Panic("boom")
+	}()
+	out := buf.String()
+	if n := strings.Count(out, `"msg":"queued"`); n != 20 {
+		t.Fatalf("queued lines before panic = %d, want 20", n)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(out), `"msg":"boom"}`) {
+		t.Fatalf("panic entry is not last: %s", out)
+	}
+}
+
+// 阻塞写, 用来把异步队列塞满
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func (w *blockingWriter) Sync() error {
+	return nil
+}
+
+func TestAsyncOverflowDrop(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	l := NewZapLoggerWithOptions(allLevelWriters(w),
+		WithWriteMode(WriteModeAsync),
+		WithQueueSize(1),
+		WithOverflowPolicy(OverflowDrop),
+	)
+	for i := 0; i < 10; i++ {
+		l.Info("drop me")
+	}
+	close(w.release)
+	if dropped := l.(*asynczaplogger).masyslog.droppedCount(); dropped == 0 {
+		t.Fatal("expected dropped entries with a full queue")
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func (errWriter) Sync() error {
+	return nil
+}
+
+func TestFileCoreRouting(t *testing.T) {
+	info, errBuf, warnUp, fallback, errOut := &syncBuffer{}, &syncBuffer{}, &syncBuffer{}, &syncBuffer{}, &syncBuffer{}
+	l := NewZapLoggerWithOptions(map[zapcore.Level]zapcore.WriteSyncer{
+		zapcore.InfoLevel:  info,
+		zapcore.ErrorLevel: errBuf,
+	},
+		WithMinLevelWriters(zapcore.WarnLevel, warnUp),
+		WithLevelWriters(zapcore.ErrorLevel, errWriter{}),
+		WithFallbackWriters(fallback),
+		WithErrorOutput(errOut),
+	)
+	l.SetLevel(zapcore.DebugLevel)
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+
+	check := func(name string, b *syncBuffer, want, notWant []string) {
+		out := b.String()
+		for _, w := range want {
+			if !strings.Contains(out, `"msg":"`+w+`"`) {
+				t.Errorf("%s: missing %q in %s", name, w, out)
+			}
+		}
+		for _, w := range notWant {
+			if strings.Contains(out, `"msg":"`+w+`"`) {
+				t.Errorf("%s: unexpected %q in %s", name, w, out)
+			}
+		}
+	}
+	check("info", info, []string{"info"}, []string{"debug", "warn", "error"})
+	check("error", errBuf, []string{"error"}, []string{"info", "warn"})
+	check("warnUp", warnUp, []string{"warn", "error"}, []string{"debug", "info"})
+	check("fallback", fallback, []string{"debug"}, []string{"info", "warn", "error"})
+	if out := errOut.String(); !strings.Contains(out, "disk full") {
+		t.Errorf("writer error not reported to error output: %q", out)
+	}
+}
+
+func TestZapLoggerAtomicLevel(t *testing.T) {
+	shared := zap.NewAtomicLevel()
+	buf := &syncBuffer{}
+	l1 := NewZapLoggerWithOptions(allLevelWriters(buf), WithAtomicLevel(shared), WithInitialLevel(zapcore.WarnLevel))
+	l2 := NewZapLoggerWithOptions(allLevelWriters(buf), WithAtomicLevel(shared), WithWriteMode(WriteModeAsync))
+	if l2.Level() != zapcore.WarnLevel {
+		t.Fatalf("shared level = %v, want warn", l2.Level())
+	}
+
+	srv := httptest.NewServer(l1.AtomicLevel())
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader(`{"level":"debug"}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if l1.Level() != zapcore.DebugLevel || l2.Level() != zapcore.DebugLevel {
+		t.Fatalf("level after PUT = %v/%v, want debug", l1.Level(), l2.Level())
+	}
+	l2.Debug("after put")
+	waitForOutput(t, buf, "after put")
+}
+
+// closeRecorder 记录是否被关闭
+type closeRecorder struct {
+	syncBuffer
+	closed int32
+}
+
+func (w *closeRecorder) Close() error {
+	atomic.AddInt32(&w.closed, 1)
+	return nil
+}
+
+func TestZapLoggerChildClose(t *testing.T) {
+	dir := t.TempDir()
+	userFile, err := os.Create(filepath.Join(dir, "user.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer userFile.Close()
+	user := &closeRecorder{}
+	owned := &closeRecorder{}
+	shared := NewRotateWriter(filepath.Join(dir, "shared.log"), RotationOptions{})
+	defer shared.Close()
+
+	for _, mode := range []WriteMode{WriteModeSync, WriteModeAsync, WriteModeHybrid} {
+		l := NewZapLoggerWithOptions(map[zapcore.Level]zapcore.WriteSyncer{zapcore.InfoLevel: userFile},
+			WithWriteMode(mode), WithLevelWriters(zapcore.InfoLevel, user, shared), WithOwnedClosers(owned))
+		child := l.With(zap.Int("mode", int(mode))).Named("child")
+		child.Info("from child")
+		if err := child.Close(); err != nil {
+			t.Fatalf("mode %d: %v", mode, err)
+		}
+	}
+	// 只关闭交给 logger 的 closer, 调用方传入的 writer (包括本包创建的) 不被关闭
+	if _, err := userFile.Write([]byte("still open\n")); err != nil || atomic.LoadInt32(&user.closed) != 0 {
+		t.Fatalf("user writers closed: %v, %d", err, user.closed)
+	}
+	shared.mu.Lock()
+	open := shared.file != nil
+	shared.mu.Unlock()
+	if !open {
+		t.Fatal("shared RotateWriter closed")
+	}
+	if n := atomic.LoadInt32(&owned.closed); n != 3 {
+		t.Fatalf("owned closed %d times", n)
+	}
+	if strings.Count(user.String(), "from child") != 3 {
+		t.Fatalf("user writer %q", user.String())
+	}
+	for _, w := range []interface{}{os.Stdout, os.Stderr, userFile, user} {
+		if _, ok := ownedCloser(w); ok {
+			t.Fatalf("%T treated as owned", w)
+		}
+	}
+	if _, ok := ownedCloser(NewRotateWriter(filepath.Join(dir, "owned.log"), RotationOptions{})); !ok {
+		t.Fatal("RotateWriter not owned")
+	}
+}
@@ -3,14 +3,17 @@ package log
 //copy from go-gin-api
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 const (
@@ -19,6 +22,12 @@ const (
 
 	// DefaultTimeLayout the default time layout;
 	DefaultTimeLayout = time.RFC3339
+
+	// DefaultFileMode the default mode of log files
+	DefaultFileMode os.FileMode = 0766
+
+	// DefaultDirMode the default mode of directories created for log files
+	DefaultDirMode os.FileMode = 0766
 )
 
 // Option custom setup config
@@ -26,12 +35,35 @@ type Option func(*option)
 
 type option struct {
 	level          zapcore.Level
-	fields         map[string]string
+	fields         []zap.Field
 	file           io.Writer
+	fileName       string
+	fileRotation   bool
+	rotation       RotationOptions
+	fileMode       os.FileMode
+	dirMode        os.FileMode
+	outputPaths    []string
+	cores          []zapcore.Core
+	otlp           *OTLPConfig
 	timeLayout     string
 	disableConsole bool
 }
 
+// FileError log file could not be prepared by NewJSONLogger
+type FileError struct {
+	Op   string // "mkdir" or "open"
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("log: %s %s: %v", e.Op, e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
 // WithDebugLevel only greater than 'level' will output
 func WithDebugLevel() Option {
 	return func(opt *option) {
@@ -60,46 +92,172 @@ func WithErrorLevel() Option {
 	}
 }
 
-// WithField add some field(s) to log
+// WithField add a string field to log, the last value wins when the key is set again
 func WithField(key, value string) Option {
 	return func(opt *option) {
-		opt.fields[key] = value
+		for i, f := range opt.fields {
+			if f.Key == key {
+				opt.fields[i] = zap.String(key, value)
+				return
+			}
+		}
+		opt.fields = append(opt.fields, zap.String(key, value))
 	}
 }
 
-// WithFileP write log to some file
-func WithFileP(file string) Option {
-	dir := filepath.Dir(file)
-	if err := os.MkdirAll(dir, 0766); err != nil {
-		panic(err)
+// WithFields add typed fields to log in the order they are added, repeated keys are all kept like zap.Logger.With
+func WithFields(fields ...zap.Field) Option {
+	return func(opt *option) {
+		opt.fields = append(opt.fields, fields...)
 	}
+}
 
-	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0766)
-	if err != nil {
-		panic(err)
+// WithHostname add "hostname" field, taken once when the logger is created
+func WithHostname() Option {
+	return func(opt *option) {
+		if hostname, err := os.Hostname(); err == nil {
+			opt.fields = append(opt.fields, zap.String("hostname", hostname))
+		}
 	}
+}
 
+// WithPid add "pid" field
+func WithPid() Option {
 	return func(opt *option) {
-		opt.file = zapcore.Lock(f)
+		opt.fields = append(opt.fields, zap.Int("pid", os.Getpid()))
+	}
+}
+
+// WithBuildInfo add "version" and "vcs_revision" fields from debug.ReadBuildInfo
+func WithBuildInfo() Option {
+	return func(opt *option) {
+		info, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		opt.fields = append(opt.fields, zap.String("version", info.Main.Version))
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				opt.fields = append(opt.fields, zap.String("vcs_revision", setting.Value))
+			}
+		}
+	}
+}
+
+// downward-API env names read by WithKubernetesMeta, field name -> env name
+var kubernetesEnv = [][2]string{
+	{"pod_name", "POD_NAME"},
+	{"pod_namespace", "POD_NAMESPACE"},
+	{"pod_ip", "POD_IP"},
+	{"node_name", "NODE_NAME"},
+}
+
+// WithKubernetesMeta add pod metadata exposed by the downward API, unset env vars are skipped
+func WithKubernetesMeta() Option {
+	return func(opt *option) {
+		for _, kv := range kubernetesEnv {
+			if value := os.Getenv(kv[1]); value != "" {
+				opt.fields = append(opt.fields, zap.String(kv[0], value))
+			}
+		}
+	}
+}
+
+// WithFileP write log to some file, the file is opened by NewJSONLogger
+func WithFileP(file string) Option {
+	return func(opt *option) {
+		opt.fileName = file
+		opt.fileRotation = false
 	}
 }
 
 // WithFileRotationP write log to some file with rotation
 func WithFileRotationP(file string) Option {
-	dir := filepath.Dir(file)
-	if err := os.MkdirAll(dir, 0766); err != nil {
-		panic(err)
+	return func(opt *option) {
+		opt.fileName = file
+		opt.fileRotation = true
 	}
+}
 
+// WithRotationOptions rotation used by WithFileRotationP, default DefaultRotationOptions
+func WithRotationOptions(rotation RotationOptions) Option {
 	return func(opt *option) {
-		opt.file = &lumberjack.Logger{ // concurrent-safed
-			Filename:   file, // 文件路径
-			MaxSize:    128,  // 单个文件最大尺寸，默认单位 M
-			MaxBackups: 300,  // 最多保留 300 个备份
-			MaxAge:     30,   // 最大时间，默认单位 day
-			LocalTime:  true, // 使用本地时间
-			Compress:   true, // 是否压缩 disabled by default
-		}
+		opt.rotation = rotation
+	}
+}
+
+// WithFileMode mode of the log file, also used for files created by WithFileRotationP, default DefaultFileMode
+func WithFileMode(mode os.FileMode) Option {
+	return func(opt *option) {
+		opt.fileMode = mode
+	}
+}
+
+// WithDirMode mode of the directories created for the log file (with or without rotation), default DefaultDirMode
+func WithDirMode(mode os.FileMode) Option {
+	return func(opt *option) {
+		opt.dirMode = mode
+	}
+}
+
+// openFile create the log file so mkdir/permission errors come out of NewJSONLogger
+func (opt *option) openFile() (io.Writer, io.Closer, error) {
+	dir := filepath.Dir(opt.fileName)
+	if err := os.MkdirAll(dir, opt.dirMode); err != nil {
+		return nil, nil, &FileError{Op: "mkdir", Path: dir, Err: err}
+	}
+
+	f, err := os.OpenFile(opt.fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, opt.fileMode)
+	if err != nil {
+		return nil, nil, &FileError{Op: "open", Path: opt.fileName, Err: err}
+	}
+	if !opt.fileRotation {
+		return zapcore.Lock(f), f, nil
+	}
+	// RotateWriter opens the file itself and keeps the mode of an existing file
+	_ = f.Close()
+
+	rotation := opt.rotation
+	if rotation.FileMode == 0 {
+		rotation.FileMode = opt.fileMode
+	}
+	if rotation.DirMode == 0 {
+		rotation.DirMode = opt.dirMode
+	}
+	w := NewRotateWriter(opt.fileName, rotation) // concurrent-safed
+	return w, w, nil
+}
+
+// DefaultRotationOptions rotation used by WithFileRotationP
+func DefaultRotationOptions() RotationOptions {
+	return RotationOptions{
+		MaxSize:    128,  // 单个文件最大尺寸，默认单位 M
+		MaxBackups: 300,  // 最多保留 300 个备份
+		MaxAge:     30,   // 最大时间，默认单位 day
+		LocalTime:  true, // 使用本地时间
+		Compress:   true, // 是否压缩 disabled by default
+	}
+}
+
+// WithOutputPaths extra outputs opened by OpenSinks, closed by the closeAll of OpenJSONLogger
+func WithOutputPaths(paths ...string) Option {
+	return func(opt *option) {
+		opt.outputPaths = append(opt.outputPaths, paths...)
+	}
+}
+
+// WithCores extra cores such as NewSyslogCore, they keep their own level enabler
+func WithCores(cores ...zapcore.Core) Option {
+	return func(opt *option) {
+		opt.cores = append(opt.cores, cores...)
+	}
+}
+
+// WithOTLP export logs to an OTLP/HTTP collector, the static fields (WithFields, WithHostname ...)
+// become resource attributes instead of record attributes
+func WithOTLP(cfg OTLPConfig) Option {
+	return func(opt *option) {
+		opt.otlp = &cfg
 	}
 }
 
@@ -118,12 +276,47 @@ func WithDisableConsole() Option {
 }
 
 // NewJSONLogger return a json-encoder zap logger,
+// a *FileError is returned when the log file can not be created.
+// The file, WithOutputPaths and WithOTLP outputs are never closed, use OpenJSONLogger to close them
 func NewJSONLogger(opts ...Option) (*zap.Logger, error) {
-	opt := &option{level: DefaultLevel, fields: make(map[string]string)}
+	logger, _, err := OpenJSONLogger(opts...)
+	return logger, err
+}
+
+// OpenJSONLogger same as NewJSONLogger, closeAll syncs the logger and closes the log file,
+// the WithOutputPaths outputs and the WithOTLP core. Cores passed by WithCores are not closed
+func OpenJSONLogger(opts ...Option) (logger *zap.Logger, closeAll func(), err error) {
+	var closers []func()
+	closeOpened := func() {
+		for _, fn := range closers {
+			fn()
+		}
+	}
+	defer func() {
+		if err != nil {
+			closeOpened()
+		}
+	}()
+
+	opt := &option{
+		level:    DefaultLevel,
+		fileMode: DefaultFileMode,
+		dirMode:  DefaultDirMode,
+		rotation: DefaultRotationOptions(),
+	}
 	for _, f := range opts {
 		f(opt)
 	}
 
+	if opt.fileName != "" {
+		file, c, err := opt.openFile()
+		if err != nil {
+			return nil, nil, err
+		}
+		opt.file = file
+		closers = append(closers, func() { _ = c.Close() })
+	}
+
 	timeLayout := DefaultTimeLayout
 	if opt.timeLayout != "" {
 		timeLayout = opt.timeLayout
@@ -187,24 +380,64 @@ func NewJSONLogger(opts ...Option) (*zap.Logger, error) {
 		)
 	}
 
-	logger := zap.New(core,
+	if len(opt.outputPaths) > 0 {
+		outputs, closeOutputs, err := OpenSinks(opt.outputPaths...)
+		if err != nil {
+			return nil, nil, err
+		}
+		closers = append(closers, closeOutputs)
+		core = zapcore.NewTee(core,
+			zapcore.NewCore(jsonEncoder, outputs,
+				zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
+					return lvl >= opt.level
+				}),
+			),
+		)
+	}
+
+	if len(opt.cores) > 0 {
+		core = zapcore.NewTee(append([]zapcore.Core{core}, opt.cores...)...)
+	}
+
+	// 静态字段对 OTLP 是 resource attribute, 只加到其他 core 上
+	if len(opt.fields) > 0 {
+		core = core.With(opt.fields)
+	}
+
+	if opt.otlp != nil {
+		cfg := *opt.otlp
+		cfg.Resource = append(append([]zap.Field(nil), opt.fields...), cfg.Resource...)
+		otlpCore, err := NewOTLPCore(cfg, zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
+			return lvl >= opt.level
+		}))
+		if err != nil {
+			return nil, nil, err
+		}
+		closers = append(closers, func() { _ = otlpCore.(io.Closer).Close() })
+		core = zapcore.NewTee(core, otlpCore)
+	}
+
+	logger = zap.New(core,
 		zap.AddCaller(),
 		zap.ErrorOutput(stderr),
 	)
+	closers = append([]func(){func() { _ = logger.Sync() }}, closers...)
 
-	for key, value := range opt.fields {
-		logger = logger.WithOptions(zap.Fields(zapcore.Field{Key: key, Type: zapcore.StringType, String: value}))
-	}
-	return logger, nil
+	return logger, closeOpened, nil
 }
 
-var _ Meta = (*meta)(nil)
+var (
+	_ Meta = (*meta)(nil)
+	_ Meta = (*fieldMeta)(nil)
+	_ Meta = (*groupMeta)(nil)
+)
 
 // Meta key-value
 type Meta interface {
 	Key() string
 	Value() interface{}
 	meta()
+	field() zap.Field
 }
 
 type meta struct {
@@ -222,12 +455,143 @@ func (m *meta) Value() interface{} {
 
 func (m *meta) meta() {}
 
+func (m *meta) field() zap.Field {
+	return zap.Any(m.key, m.value)
+}
+
 // NewMeta create meat
 func NewMeta(key string, value interface{}) Meta {
 	return &meta{key: key, value: value}
 }
 
-// WrapMeta wrap meta to zap fields
+// fieldMeta typed meta, the zap field is built once without reflection
+type fieldMeta struct {
+	f zap.Field
+}
+
+func (m *fieldMeta) Key() string {
+	return m.f.Key
+}
+
+func (m *fieldMeta) Value() interface{} {
+	switch m.f.Type {
+	case zapcore.StringType:
+		return m.f.String
+	case zapcore.Int64Type:
+		return m.f.Integer
+	case zapcore.BoolType:
+		return m.f.Integer == 1
+	case zapcore.Float64Type:
+		return math.Float64frombits(uint64(m.f.Integer))
+	case zapcore.DurationType:
+		return time.Duration(m.f.Integer)
+	case zapcore.TimeType:
+		if loc, ok := m.f.Interface.(*time.Location); ok {
+			return time.Unix(0, m.f.Integer).In(loc)
+		}
+		return time.Unix(0, m.f.Integer)
+	}
+	return m.f.Interface
+}
+
+func (m *fieldMeta) meta() {}
+
+func (m *fieldMeta) field() zap.Field {
+	return m.f
+}
+
+// MetaString string meta
+func MetaString(key, value string) Meta {
+	return &fieldMeta{f: zap.String(key, value)}
+}
+
+// MetaInt int meta
+func MetaInt(key string, value int) Meta {
+	return &fieldMeta{f: zap.Int(key, value)}
+}
+
+// MetaInt64 int64 meta
+func MetaInt64(key string, value int64) Meta {
+	return &fieldMeta{f: zap.Int64(key, value)}
+}
+
+// MetaFloat64 float64 meta
+func MetaFloat64(key string, value float64) Meta {
+	return &fieldMeta{f: zap.Float64(key, value)}
+}
+
+// MetaBool bool meta
+func MetaBool(key string, value bool) Meta {
+	return &fieldMeta{f: zap.Bool(key, value)}
+}
+
+// MetaDuration duration meta, encoded by the logger's EncodeDuration
+func MetaDuration(key string, value time.Duration) Meta {
+	return &fieldMeta{f: zap.Duration(key, value)}
+}
+
+// MetaTime time meta, encoded by the logger's EncodeTime
+func MetaTime(key string, value time.Time) Meta {
+	return &fieldMeta{f: zap.Time(key, value)}
+}
+
+// MetaObject meta encoded as a nested object by its MarshalLogObject
+func MetaObject(key string, value zapcore.ObjectMarshaler) Meta {
+	return &fieldMeta{f: zap.Object(key, value)}
+}
+
+// groupMeta nested metas under one key
+type groupMeta struct {
+	key   string
+	metas []Meta
+}
+
+func (m *groupMeta) Key() string {
+	return m.key
+}
+
+// Value return the []Meta in the group
+func (m *groupMeta) Value() interface{} {
+	return m.metas
+}
+
+func (m *groupMeta) meta() {}
+
+func (m *groupMeta) field() zap.Field {
+	return zap.Object(m.key, m)
+}
+
+func (m *groupMeta) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, meta := range m.metas {
+		meta.field().AddTo(enc)
+	}
+	return nil
+}
+
+// MetaGroup nest metas under key, groups can be nested
+func MetaGroup(key string, metas ...Meta) Meta {
+	return &groupMeta{key: key, metas: metas}
+}
+
+// ContextWithMeta attach metas to ctx, they are merged into every WrapMetaCtx with ctx
+func ContextWithMeta(ctx context.Context, metas ...Meta) context.Context {
+	parent := MetaFromContext(ctx)
+	merged := make([]Meta, 0, len(parent)+len(metas))
+	merged = append(merged, parent...)
+	merged = append(merged, metas...)
+	return context.WithValue(ctx, metaKey, merged)
+}
+
+// MetaFromContext metas attached by ContextWithMeta
+func MetaFromContext(ctx context.Context) []Meta {
+	if ctx == nil {
+		return nil
+	}
+	metas, _ := ctx.Value(metaKey).([]Meta)
+	return metas
+}
+
+// WrapMeta wrap meta to zap fields, err is encoded by ErrorObject
 func WrapMeta(err error, metas ...Meta) (fields []zap.Field) {
 	capacity := len(metas) + 1 // namespace meta
 	if err != nil {
@@ -236,13 +600,33 @@ func WrapMeta(err error, metas ...Meta) (fields []zap.Field) {
 
 	fields = make([]zap.Field, 0, capacity)
 	if err != nil {
-		fields = append(fields, zap.Error(err))
+		fields = append(fields, ErrorObject(err))
 	}
 
 	fields = append(fields, zap.Namespace("meta"))
 	for _, meta := range metas {
-		fields = append(fields, zap.Any(meta.Key(), meta.Value()))
+		fields = append(fields, meta.field())
 	}
 
 	return
 }
+
+// WrapMetaCtx same as WrapMeta with the metas of ctx first,
+// a ctx meta is dropped when metas has the same key
+func WrapMetaCtx(ctx context.Context, err error, metas ...Meta) []zap.Field {
+	ctxMetas := MetaFromContext(ctx)
+	if len(ctxMetas) == 0 {
+		return WrapMeta(err, metas...)
+	}
+	keys := make(map[string]struct{}, len(metas))
+	for _, meta := range metas {
+		keys[meta.Key()] = struct{}{}
+	}
+	merged := make([]Meta, 0, len(ctxMetas)+len(metas))
+	for _, meta := range ctxMetas {
+		if _, ok := keys[meta.Key()]; !ok {
+			merged = append(merged, meta)
+		}
+	}
+	return WrapMeta(err, append(merged, metas...)...)
+}
@@ -1,11 +1,17 @@
 package log
 
 import (
+	"context"
+	"errors"
 	"github.com/natefinch/lumberjack"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // zap 库基本功能 test
@@ -107,3 +113,124 @@ func getEncoder2() zapcore.Encoder {
 }
 func TestTest(t *testing.T) {
 }
+
+func TestNewJSONLoggerFileError(t *testing.T) {
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只构造 option 不应该创建文件
+	unused := filepath.Join(dir, "unused", "app.log")
+	_ = WithFileP(unused)
+	if _, err := os.Stat(filepath.Dir(unused)); !os.IsNotExist(err) {
+		t.Fatalf("option created dir eagerly: %v", err)
+	}
+
+	_, err := NewJSONLogger(WithDisableConsole(), WithFileRotationP(filepath.Join(notDir, "app.log")))
+	var ferr *FileError
+	if !errors.As(err, &ferr) || ferr.Op != "mkdir" {
+		t.Fatalf("err = %v, want *FileError mkdir", err)
+	}
+
+	file := filepath.Join(dir, "logs", "app.log")
+	logger, err := NewJSONLogger(WithDisableConsole(), WithFileP(file), WithFileMode(0600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hello")
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestNewJSONLoggerFields(t *testing.T) {
+	t.Setenv("POD_NAME", "api-0")
+	t.Setenv("POD_NAMESPACE", "")
+	file := filepath.Join(t.TempDir(), "app.log")
+	logger, err := NewJSONLogger(WithDisableConsole(), WithFileP(file),
+		WithField("b", "1"),
+		WithFields(zap.Int("a", 2), zap.Bool("ok", true)),
+		WithPid(),
+		WithKubernetesMeta(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hello")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	want := `"b":"1","a":2,"ok":true,"pid":` + strconv.Itoa(os.Getpid()) + `,"pod_name":"api-0"}`
+	if !strings.Contains(out, want) {
+		t.Fatalf("output %s does not contain %s", out, want)
+	}
+	if strings.Contains(out, "pod_namespace") {
+		t.Fatalf("empty env var written: %s", out)
+	}
+}
+
+func TestNewJSONLoggerFieldLastWins(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	logger, err := NewJSONLogger(WithDisableConsole(), WithFileP(file),
+		WithField("env", "dev"), WithField("app", "api"), WithField("env", "prod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hello")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := string(data); strings.Count(out, `"env"`) != 1 || !strings.Contains(out, `"env":"prod","app":"api"`) {
+		t.Fatalf("output %s", out)
+	}
+}
+
+type metaUser struct{ id string }
+
+func (u metaUser) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("id", u.id)
+	return nil
+}
+
+func TestWrapMetaTyped(t *testing.T) {
+	ctx := ContextWithMeta(context.Background(), MetaString("trace", "t1"), MetaInt("n", 1))
+	ctx = ContextWithMeta(ctx, MetaBool("ctx", true))
+
+	at := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	fields := WrapMetaCtx(ctx, nil,
+		MetaInt("n", 2),
+		MetaGroup("req", MetaDuration("cost", time.Second), MetaGroup("user", MetaObject("obj", metaUser{id: "u1"}))),
+		MetaTime("at", at),
+	)
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	meta := enc.Fields["meta"].(map[string]interface{})
+	if meta["trace"] != "t1" || meta["ctx"] != true || meta["n"] != int64(2) {
+		t.Fatalf("meta = %#v", meta)
+	}
+	req := meta["req"].(map[string]interface{})
+	if req["cost"] != time.Second {
+		t.Fatalf("req = %#v", req)
+	}
+	user := req["user"].(map[string]interface{})["obj"].(map[string]interface{})
+	if user["id"] != "u1" {
+		t.Fatalf("user = %#v", user)
+	}
+	if got := MetaTime("at", at).Value().(time.Time); !got.Equal(at) {
+		t.Fatalf("MetaTime value = %v", got)
+	}
+	if n := len(fields); n != 6 { // namespace + trace, ctx, n, req, at
+		t.Fatalf("len(fields) = %d", n)
+	}
+}
@@ -3,7 +3,6 @@ package log
 import (
 	"net/http"
 
-	"github.com/natefinch/lumberjack"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -28,14 +27,12 @@ func getEncoder() zapcore.Encoder {
 }
 
 func getLogWriter() zapcore.WriteSyncer {
-	lumberJackLogger := &lumberjack.Logger{
-		Filename:   "./test.log",
+	return NewRotateWriter("./test.log", RotationOptions{
 		MaxSize:    1,
 		MaxBackups: 5,
 		MaxAge:     30,
 		Compress:   false,
-	}
-	return zapcore.AddSync(lumberJackLogger)
+	})
 }
 
 func simpleHttpGet(url string) {